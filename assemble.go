@@ -0,0 +1,156 @@
+package vmu
+
+import (
+	"io"
+	"sort"
+	"time"
+)
+
+// Product is a science product split across LRSD packets of the same origin
+// and UPI.
+type Product struct {
+	Origin      uint8
+	UPI         string
+	Acquisition time.Time
+	// fragments in the order of their counter
+	Fragments []Packet
+}
+
+// Missing returns the counters absent between the first and the last
+// fragments of p.
+func (p *Product) Missing() []uint32 {
+	var ms []uint32
+	for i := 1; i < len(p.Fragments); i++ {
+		prev, curr := p.Fragments[i-1].DataHeader.Counter, p.Fragments[i].DataHeader.Counter
+		for c := prev + 1; c != curr; c++ {
+			ms = append(ms, c)
+		}
+	}
+	return ms
+}
+
+func (p *Product) Complete() bool {
+	return len(p.Missing()) == 0
+}
+
+// Filename is the name of the first fragment of p.
+func (p *Product) Filename() string {
+	if len(p.Fragments) == 0 {
+		return ""
+	}
+	return p.Fragments[0].Filename()
+}
+
+// WriteTo writes the payloads of the fragments of p one after the other.
+func (p *Product) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	for _, f := range p.Fragments {
+		c, err := w.Write(f.Data)
+		n += int64(c)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// next tells whether f is the next fragment of p, a copy of its last fragment
+// or a packet of another product. The counter of the next fragment is ahead of
+// the one of the last fragment by the number of lost fragments plus one, and
+// its acquisition time is not earlier than the one of the last fragment and,
+// if window is set, not later than window after it. When f directly follows
+// the last fragment on the channel, its VMU sequence moves forward with the
+// counter too: otherwise packets of other streams came in between and the
+// sequence is not compared.
+func (p *Product) next(f Packet, window time.Duration, follows bool) (next, dup bool) {
+	last := p.Fragments[len(p.Fragments)-1]
+	var (
+		counter  = f.DataHeader.Counter - last.DataHeader.Counter
+		sequence = f.VMUHeader.Sequence - last.VMUHeader.Sequence
+		delta    = f.DataHeader.Acquisition().Sub(last.DataHeader.Acquisition())
+	)
+	if counter == 0 && sequence == 0 {
+		return false, true
+	}
+	if counter == 0 || counter > WrapWindow || (follows && counter != sequence) {
+		return false, false
+	}
+	if delta < 0 || (window > 0 && delta > window) {
+		return false, false
+	}
+	return true, false
+}
+
+type productKey struct {
+	Origin   uint8
+	UPI      string
+	Realtime bool
+}
+
+// Assembler groups LRSD packets into products. Fragments of a product are
+// packets of the same origin and UPI with increasing counters: a packet starts
+// a new product when its counter does not move forward, when its VMU sequence
+// does not move forward with the counter while no other stream came in
+// between, or when its acquisition time goes backward or is more than Window
+// after the one of the previous fragment. A zero Window puts no limit on the
+// time between fragments.
+type Assembler struct {
+	Window time.Duration
+
+	pending map[productKey]*Product
+	// stream of the previous LRSD packet
+	last productKey
+}
+
+func NewAssembler(window time.Duration) *Assembler {
+	return &Assembler{
+		Window:  window,
+		pending: make(map[productKey]*Product),
+	}
+}
+
+// Add adds p to its product. It returns the product previously pending for
+// the origin and UPI of p when p starts a new one.
+func (a *Assembler) Add(p Packet) *Product {
+	if p.VMUHeader.Channel != LRSD {
+		return nil
+	}
+	k := productKey{
+		Origin:   p.DataHeader.Origin,
+		UPI:      string(p.DataHeader.UserInfo()),
+		Realtime: p.IsRealtime(),
+	}
+	follows := a.last == k
+	a.last = k
+
+	curr, ok := a.pending[k]
+	if ok {
+		switch next, dup := curr.next(p, a.Window, follows); {
+		case dup:
+			return nil
+		case next:
+			curr.Fragments = append(curr.Fragments, p)
+			return nil
+		}
+	}
+	a.pending[k] = &Product{
+		Origin:      k.Origin,
+		UPI:         k.UPI,
+		Acquisition: p.DataHeader.Acquisition(),
+		Fragments:   []Packet{p},
+	}
+	return curr
+}
+
+// Flush returns the pending products ordered by acquisition time.
+func (a *Assembler) Flush() []*Product {
+	ps := make([]*Product, 0, len(a.pending))
+	for k, p := range a.pending {
+		ps = append(ps, p)
+		delete(a.pending, k)
+	}
+	sort.Slice(ps, func(i, j int) bool {
+		return ps[i].Acquisition.Before(ps[j].Acquisition)
+	})
+	return ps
+}
@@ -0,0 +1,110 @@
+package vmu
+
+import (
+	"testing"
+	"time"
+)
+
+func fragment(sequence, counter uint32, acq time.Duration) Packet {
+	var p Packet
+	p.VMUHeader = VMUHeader{Channel: LRSD, Origin: 0x33, Sequence: sequence}
+	p.DataHeader = DataHeader{Property: 0x10, Origin: 0x33, Counter: counter, AcqTime: acq}
+	copy(p.DataHeader.UPI[:], "TEST")
+	return p
+}
+
+func withUPI(p Packet, upi string) Packet {
+	p.DataHeader.UPI = [UPILen]byte{}
+	copy(p.DataHeader.UPI[:], upi)
+	return p
+}
+
+func assemble(a *Assembler, ps ...Packet) []*Product {
+	var done []*Product
+	for _, p := range ps {
+		if d := a.Add(p); d != nil {
+			done = append(done, d)
+		}
+	}
+	return append(done, a.Flush()...)
+}
+
+func TestAssemblerSplitsProducts(t *testing.T) {
+	const ms = time.Millisecond
+	data := []struct {
+		Name    string
+		Window  time.Duration
+		Packets []Packet
+		Sizes   []int
+		Missing []int
+	}{
+		{
+			Name:    "no-window",
+			Packets: []Packet{fragment(1, 10, 0), fragment(2, 11, 5*ms), fragment(3, 12, time.Hour)},
+			Sizes:   []int{3},
+			Missing: []int{0},
+		},
+		{
+			Name:    "window",
+			Window:  100 * ms,
+			Packets: []Packet{fragment(1, 10, 0), fragment(2, 11, 5*ms), fragment(3, 12, 200*ms), fragment(4, 13, 250*ms)},
+			Sizes:   []int{2, 2},
+			Missing: []int{0, 0},
+		},
+		{
+			Name:    "lost-fragments",
+			Packets: []Packet{fragment(1, 10, 0), fragment(4, 13, 5*ms), fragment(5, 14, 6*ms)},
+			Sizes:   []int{3},
+			Missing: []int{2},
+		},
+		{
+			Name:    "sequence-change",
+			Packets: []Packet{fragment(1, 10, 0), fragment(2, 11, 5*ms), fragment(9, 12, 6*ms), fragment(10, 13, 7*ms)},
+			Sizes:   []int{2, 2},
+			Missing: []int{0, 0},
+		},
+		{
+			Name: "interleaved-upis",
+			Packets: []Packet{
+				fragment(1, 10, 0), withUPI(fragment(2, 10, ms), "OTHER"),
+				fragment(3, 11, 2*ms), withUPI(fragment(4, 11, 3*ms), "OTHER"),
+				fragment(5, 12, 4*ms), withUPI(fragment(6, 12, 5*ms), "OTHER"),
+			},
+			Sizes:   []int{3, 3},
+			Missing: []int{0, 0},
+		},
+		{
+			Name:    "acquisition-backward",
+			Packets: []Packet{fragment(1, 10, 10*ms), fragment(2, 11, 20*ms), fragment(3, 12, 0)},
+			Sizes:   []int{2, 1},
+			Missing: []int{0, 0},
+		},
+		{
+			Name:    "duplicate",
+			Packets: []Packet{fragment(1, 10, 0), fragment(2, 11, 5*ms), fragment(2, 11, 5*ms), fragment(3, 12, 6*ms)},
+			Sizes:   []int{3},
+			Missing: []int{0},
+		},
+		{
+			Name:    "counter-wrap",
+			Packets: []Packet{fragment(1, 1<<32-2, 0), fragment(2, 1<<32-1, ms), fragment(4, 1, 2*ms)},
+			Sizes:   []int{3},
+			Missing: []int{1},
+		},
+	}
+	for _, d := range data {
+		ps := assemble(NewAssembler(d.Window), d.Packets...)
+		if len(ps) != len(d.Sizes) {
+			t.Errorf("%s: %d products, want %d", d.Name, len(ps), len(d.Sizes))
+			continue
+		}
+		for i, p := range ps {
+			if len(p.Fragments) != d.Sizes[i] {
+				t.Errorf("%s: product %d: %d fragments, want %d", d.Name, i, len(p.Fragments), d.Sizes[i])
+			}
+			if m := len(p.Missing()); m != d.Missing[i] {
+				t.Errorf("%s: product %d: %d missing, want %d", d.Name, i, m, d.Missing[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package vmu_test
+
+import (
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/busoc/vmu"
+	"github.com/busoc/vmu/vmutest"
+)
+
+var benchSizes = []struct {
+	Width  int
+	Height int
+}{
+	{Width: 64, Height: 48},
+	{Width: 640, Height: 480},
+	{Width: 1024, Height: 1024},
+}
+
+// records gives one packet by call to Read.
+type records [][]byte
+
+func (r *records) Read(bs []byte) (int, error) {
+	if len(*r) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(bs, (*r)[0])
+	*r = (*r)[1:]
+	return n, nil
+}
+
+var benchPackets = make(map[string][][]byte)
+
+// packets returns the records of 8 images of type t and of the given size. They
+// are generated once and shared by the benchmarks.
+func packets(b *testing.B, t vmu.ImageType, width, height int) [][]byte {
+	b.Helper()
+	k := fmt.Sprintf("%s/%dx%d", t, width, height)
+	if rs, ok := benchPackets[k]; ok {
+		return rs
+	}
+	cfg := vmutest.Default()
+	cfg.Count, cfg.Channels, cfg.Types = 8, []uint8{vmu.VIC1}, []vmu.ImageType{t}
+	cfg.Width, cfg.Height = width, height
+
+	var (
+		rs [][]byte
+		g  = vmutest.New(cfg)
+	)
+	for {
+		_, buf, err := g.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+		rs = append(rs, append([]byte(nil), buf...))
+	}
+	benchPackets[k] = rs
+	return rs
+}
+
+// BenchmarkDecode decodes packets with a copy of their payload. The buffer of
+// the decoder, created at each iteration, comes from a pool and is not counted
+// in the allocations.
+func BenchmarkDecode(b *testing.B) {
+	benchmarkDecode(b, (*vmu.Decoder).Decode)
+}
+
+// BenchmarkDecodeView decodes the same packets as BenchmarkDecode but without
+// copying their payload.
+func BenchmarkDecodeView(b *testing.B) {
+	benchmarkDecode(b, (*vmu.Decoder).DecodeView)
+}
+
+func benchmarkDecode(b *testing.B, decode func(*vmu.Decoder, bool) (vmu.Packet, error)) {
+	for _, t := range vmutest.AllTypes {
+		for _, s := range benchSizes {
+			b.Run(fmt.Sprintf("%s/%dx%d", t, s.Width, s.Height), func(b *testing.B) {
+				rs := packets(b, t, s.Width, s.Height)
+				var size int64
+				for _, r := range rs {
+					size += int64(len(r))
+				}
+				b.SetBytes(size)
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					r := records(rs)
+					d := vmu.NewDecoder(&r, nil)
+					for {
+						_, err := decode(d, true)
+						if err == io.EOF {
+							break
+						}
+						if err != nil {
+							b.Fatal(err)
+						}
+					}
+					d.Close()
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkExportImage converts raw images to png.
+func BenchmarkExportImage(b *testing.B) {
+	for _, t := range vmutest.AllTypes {
+		switch t {
+		case vmu.JPEG, vmu.PNG, vmu.H264:
+			continue
+		}
+		for _, s := range benchSizes {
+			b.Run(fmt.Sprintf("%s/%dx%d", t, s.Width, s.Height), func(b *testing.B) {
+				rs := packets(b, t, s.Width, s.Height)
+				p, err := vmu.DecodePacket(rs[0], true)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.SetBytes(int64(len(p.Data)))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if err := p.ExportImage(io.Discard, "png"); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -5,8 +5,6 @@ import (
 	"hash"
 )
 
-var defaultSum hrdlSum
-
 type hrdlSum struct {
 	sum uint32
 }
@@ -17,10 +15,9 @@ func SumHRDL() hash.Hash32 {
 }
 
 func Sum(bs []byte) uint32 {
-	defer defaultSum.Reset()
-
-	defaultSum.Write(bs)
-	return defaultSum.Sum32()
+	var h hrdlSum
+	h.Write(bs)
+	return h.Sum32()
 }
 
 func (h *hrdlSum) Size() int      { return 4 }
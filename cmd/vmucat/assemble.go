@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/busoc/rt"
+	"github.com/busoc/vmu"
+	"github.com/midbel/cli"
+)
+
+func runAssemble(cmd *cli.Command, args []string) error {
+	var q query
+	q.Register(&cmd.Flag)
+	datadir := cmd.Flag.String("d", "", "datadir")
+	window := cmd.Flag.Duration("w", 0, "maximum acquisition time difference between consecutive fragments of a product (0: no limit)")
+	keepInvalid := cmd.Flag.Bool("e", false, "keep invalid packets")
+	name := cmd.Flag.String("t", vmu.DefaultNaming, "naming template of products")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	naming, err := vmu.ParseNaming(*name)
+	if err != nil {
+		return err
+	}
+	q.Channel = int(vmu.LRSD)
+	filter := q.Query()
+
+	// every LRSD packet goes to the assembler, whose products are selected
+	// with their first fragment: the VMU sequence of fragments is only
+	// compared when no other stream came in between.
+	mr, err := openArchives(cmd.Flag.Args(), vmu.Query{Channel: vmu.LRSD})
+	if err != nil {
+		return err
+	}
+	defer mr.Close()
+
+	if err := os.MkdirAll(*datadir, 0755); err != nil {
+		return err
+	}
+
+	var (
+		complete, partial int
+		a                 = vmu.NewAssembler(*window)
+		d                 = vmu.NewDecoder(rt.NewReader(mr), nil)
+	)
+	defer d.Close()
+	write := func(p *vmu.Product) error {
+		if p == nil || !filter.Keep(p.Fragments[0]) {
+			return nil
+		}
+		if p.Complete() {
+			complete++
+		} else {
+			partial++
+		}
+		return writeProduct(*datadir, naming, p)
+	}
+	for {
+		switch p, err := d.Decode(true); err {
+		case nil, vmu.ErrInvalid:
+			if err == vmu.ErrInvalid && !*keepInvalid {
+				continue
+			}
+			if err := write(a.Add(p)); err != nil {
+				return err
+			}
+		case vmu.ErrSkip:
+		case io.EOF:
+			for _, p := range a.Flush() {
+				if err := write(p); err != nil {
+					return err
+				}
+			}
+			log.Printf("%d products reassembled (%d partial)", complete+partial, partial)
+			return nil
+		default:
+			return err
+		}
+	}
+}
+
+// writeProduct writes the payload of a complete product in dir, in a file named
+// by n from its first fragment. For an incomplete product, it writes a
+// .partial file listing the missing counters.
+func writeProduct(dir string, n *vmu.Naming, p *vmu.Product) error {
+	name := filepath.Join(dir, n.Filename(p.Fragments[0], ""))
+	ms := p.Missing()
+	if len(ms) > 0 {
+		name += ".partial"
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	w, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	if len(ms) == 0 {
+		_, err = p.WriteTo(w)
+		return err
+	}
+	first, last := p.Fragments[0].DataHeader.Counter, p.Fragments[len(p.Fragments)-1].DataHeader.Counter
+	fmt.Fprintf(w, "# %s %02x %d-%d: %d fragments, %d missing\n", p.UPI, p.Origin, first, last, len(p.Fragments), len(ms))
+	for _, c := range ms {
+		if _, err := fmt.Fprintln(w, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/busoc/vmu"
+)
+
+type row struct {
+	key
+	coze
+}
+
+// fillRows returns the rows of stats ordered by channel, origin, upi and
+// time. When interval is set, empty buckets between the first and the last
+// bucket of each group are added with zero values.
+func fillRows(stats map[key]coze, interval time.Duration) []row {
+	rows := make([]row, 0, len(stats))
+	for k, cz := range stats {
+		rows = append(rows, row{key: k, coze: cz})
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].key.Less(rows[j].key)
+	})
+	if interval <= 0 || len(rows) == 0 {
+		return rows
+	}
+	filled := make([]row, 0, len(rows))
+	for i, r := range rows {
+		if i > 0 && rows[i-1].key.Same(r.key) {
+			prev := rows[i-1].Time
+			for w := prev.Add(interval); w.Before(r.Time); w = w.Add(interval) {
+				k := r.key
+				k.Time = w
+				filled = append(filled, row{key: k})
+			}
+		}
+		filled = append(filled, r)
+	}
+	return filled
+}
+
+// Same reports whether k and other belong to the same group, regardless of
+// their bucket time.
+func (k key) Same(other key) bool {
+	return k.Channel == other.Channel && k.Origin == other.Origin && k.UPI == other.UPI
+}
+
+func (k key) Less(other key) bool {
+	if k.Channel != other.Channel {
+		return k.Channel < other.Channel
+	}
+	if k.Origin != other.Origin {
+		return k.Origin < other.Origin
+	}
+	if k.UPI != other.UPI {
+		return k.UPI < other.UPI
+	}
+	return k.Time.Before(other.Time)
+}
+
+func (k key) Label(g group) string {
+	var parts []string
+	if g.Has(groupChannel) {
+		parts = append(parts, string(vmu.WhichChannel(k.Channel)))
+	}
+	if g.Has(groupOrigin) {
+		parts = append(parts, fmt.Sprintf("%02x", k.Origin))
+	}
+	if g.Has(groupUPI) {
+		parts = append(parts, k.UPI)
+	}
+	return strings.Join(parts, "/")
+}
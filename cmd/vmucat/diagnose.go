@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/busoc/rt"
+	"github.com/busoc/vmu"
+	"github.com/midbel/cli"
+	"github.com/midbel/linewriter"
+)
+
+func runDiagnose(cmd *cli.Command, args []string) error {
+	csv := cmd.Flag.Bool("c", false, "csv format")
+	attributed := cmd.Flag.Bool("a", false, "only packets with trusted headers")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	mr, err := browseArchives(cmd.Flag.Args())
+	if err != nil {
+		return err
+	}
+	defer mr.Close()
+
+	buf := vmu.AcquireBuffer()
+	defer vmu.ReleaseBuffer(buf)
+
+	var (
+		r      = rt.NewReader(mr)
+		buffer = *buf
+		line   = Line(*csv)
+		count  int
+		bad    int
+	)
+	for {
+		n, err := r.Read(buffer)
+		switch err {
+		case nil:
+		case io.EOF:
+			fmt.Fprintf(os.Stderr, "%d bad packets out of %d\n", bad, count)
+			return nil
+		default:
+			return err
+		}
+		count++
+		_, err = vmu.DecodePacket(buffer[:n], false)
+		if err == nil {
+			continue
+		}
+		bad++
+		d := vmu.Diagnose(buffer[:n])
+		if *attributed && !d.Attributed {
+			continue
+		}
+		appendDiagnosis(line, count, n, d, err)
+		if _, err := io.Copy(os.Stdout, line); err != nil {
+			return err
+		}
+	}
+}
+
+func appendDiagnosis(line *linewriter.Writer, ix, size int, d vmu.Diagnosis, err error) {
+	v, c := d.VMUHeader, d.DataHeader
+
+	line.AppendUint(uint64(ix), 8, linewriter.AlignRight)
+	line.AppendUint(uint64(size), 7, linewriter.AlignRight)
+	line.AppendBytes(vmu.WhichChannel(v.Channel), 4, linewriter.AlignCenter|linewriter.Text)
+	line.AppendUint(uint64(v.Sequence), 7, linewriter.AlignRight)
+	line.AppendTime(v.Timestamp(), rt.TimeFormat, linewriter.AlignCenter)
+	if d.Attributed {
+		line.AppendUint(uint64(c.Origin), 2, linewriter.AlignCenter|linewriter.Hex|linewriter.WithZero)
+		line.AppendUint(uint64(c.Counter), 8, linewriter.AlignRight)
+		line.AppendBytes(c.UserInfo(), 16, linewriter.AlignLeft|linewriter.Text)
+	} else {
+		line.AppendBytes(vmu.Unknown, 2, linewriter.AlignCenter|linewriter.Text)
+		line.AppendBytes(vmu.Unknown, 8, linewriter.AlignRight|linewriter.Text)
+		line.AppendBytes(vmu.Unknown, 16, linewriter.AlignLeft|linewriter.Text)
+	}
+	line.AppendString(err.Error(), 16, linewriter.AlignLeft|linewriter.Text)
+	line.AppendString(d.Hint.String(), 9, linewriter.AlignLeft|linewriter.Text)
+	line.AppendInt(d.Delta, 8, linewriter.AlignRight)
+	line.AppendUint(uint64(d.Missing), 2, linewriter.AlignRight)
+}
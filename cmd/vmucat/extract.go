@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/busoc/rt"
+	"github.com/busoc/vmu"
+	"github.com/midbel/cli"
+)
+
+func runExtract(cmd *cli.Command, args []string) error {
+	var q query
+	q.Register(&cmd.Flag)
+	cmd.Flag.IntVar(&q.Channel, "c", 0, "channel")
+	datadir := cmd.Flag.String("d", "", "datadir")
+	format := cmd.Flag.String("f", "", "convert images (png, jpg) or science data (csv, json) to format")
+	schemas := cmd.Flag.String("s", "", "comma separated list of science schema files")
+	keepInvalid := cmd.Flag.Bool("e", false, "keep invalid packets")
+	by := cmd.Flag.String("r", "", "split files by stream and/or upi")
+	name := cmd.Flag.String("t", vmu.DefaultNaming, "naming template of files")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	naming, err := vmu.ParseNaming(*name)
+	if err != nil {
+		return err
+	}
+	r, err := parseRoute(*by)
+	if err != nil {
+		return err
+	}
+	if err := loadSchemas(*schemas); err != nil {
+		return err
+	}
+	filter := q.Query()
+	mr, err := openArchives(cmd.Flag.Args(), filter)
+	if err != nil {
+		return err
+	}
+	defer mr.Close()
+
+	var count, skipped int
+	d := vmu.NewDecoder(rt.NewReader(mr), nil)
+	defer d.Close()
+	for {
+		switch p, err := d.DecodeView(true); err {
+		case nil, vmu.ErrInvalid:
+			if err == vmu.ErrInvalid && !*keepInvalid {
+				skipped++
+				continue
+			}
+			if !filter.Keep(p) {
+				continue
+			}
+			dir := filepath.Join(*datadir, r.Key(p).Dir())
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+			if err := extractPacket(dir, *format, naming, p); err != nil {
+				log.Printf("%s: %s", naming.Filename(p, ""), err)
+				skipped++
+				continue
+			}
+			count++
+		case vmu.ErrSkip:
+			skipped++
+		case io.EOF:
+			log.Printf("%d packets extracted (%d skipped)", count, skipped)
+			return nil
+		default:
+			return err
+		}
+	}
+}
+
+// extractPacket writes the payload of p in dir. Images are converted to an
+// image format and science data with a registered decoder to csv or json,
+// other payloads are written as is. The file is named by n.
+func extractPacket(dir, format string, n *vmu.Naming, p vmu.Packet) error {
+	name := n.Filename(p, "")
+	convert := canConvert(p, format)
+	if convert {
+		ext := format
+		if t := p.DataHeader.Type; p.VMUHeader.Channel != vmu.LRSD && (t == vmu.JPEG || t == vmu.PNG) {
+			ext = t.String()
+		}
+		name = n.Filename(p, ext)
+	}
+	file := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	w, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	if convert {
+		err = p.Export(w, format)
+	} else {
+		_, err = w.Write(p.Data)
+	}
+	return err
+}
+
+func canConvert(p vmu.Packet, format string) bool {
+	switch format {
+	case "":
+		return false
+	case "csv", "json":
+		_, ok := vmu.LookupScience(p)
+		return ok
+	default:
+		return p.VMUHeader.Channel != vmu.LRSD
+	}
+}
+
+// loadSchemas registers the science schemas of a comma separated list of files.
+func loadSchemas(list string) error {
+	if list == "" {
+		return nil
+	}
+	for _, file := range strings.Split(list, ",") {
+		s, err := vmu.LoadSchema(strings.TrimSpace(file))
+		if err != nil {
+			return err
+		}
+		if err := s.Register(); err != nil {
+			return fmt.Errorf("%s: %s", file, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/busoc/vmu"
+)
+
+// browse returns a reader over the archives found in files. When follow is
+// set, the reader keeps waiting for new packets appended to the last file and
+// for new files created in the directories until the process is interrupted.
+func browse(files []string, follow bool, every time.Duration) (io.ReadCloser, error) {
+	if !follow {
+		return browseArchives(files)
+	}
+	t := &tail{
+		every: every,
+		done:  make(chan struct{}),
+	}
+	for _, f := range files {
+		i, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		t.roots = append(t.roots, &root{base: f, dir: i.IsDir()})
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		<-sig
+		signal.Stop(sig)
+		t.Close()
+	}()
+	return t, nil
+}
+
+// tail follows the archives of a set of roots (directories or files). The
+// files of each root are read one after the other in name order and tail
+// waits at the end of the last one for new packets or a new file.
+//
+// Packets are read as whole records (size prefix and body) so that records
+// of different roots are never mixed.
+type tail struct {
+	roots []*root
+	every time.Duration
+	// next root to look at, roots are read in turn
+	index int
+
+	record []byte
+	offset int
+
+	once sync.Once
+	done chan struct{}
+}
+
+func (t *tail) Read(bs []byte) (int, error) {
+	for t.offset >= len(t.record) {
+		select {
+		case <-t.done:
+			for _, r := range t.roots {
+				r.Close()
+			}
+			return 0, io.EOF
+		default:
+		}
+		ok, err := t.fill()
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			t.wait()
+		}
+	}
+	n := copy(bs, t.record[t.offset:])
+	t.offset += n
+	return n, nil
+}
+
+// fill reads the next complete record from the first root, in turn, having
+// one.
+func (t *tail) fill() (bool, error) {
+	for i := 0; i < len(t.roots); i++ {
+		r := t.roots[(t.index+i)%len(t.roots)]
+		buf, err := r.Next(t.record[:0])
+		if err != nil {
+			return false, err
+		}
+		if buf != nil {
+			t.index = (t.index + i + 1) % len(t.roots)
+			t.record, t.offset = buf, 0
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (t *tail) Close() error {
+	t.once.Do(func() {
+		close(t.done)
+	})
+	return nil
+}
+
+func (t *tail) wait() {
+	select {
+	case <-t.done:
+	case <-time.After(t.every):
+	}
+}
+
+// rotationGrace is the time during which a file is still read after a newer
+// file has been created next to it.
+const rotationGrace = 10 * time.Second
+
+// root is the position of a tail in one of its roots.
+type root struct {
+	base string
+	dir  bool
+
+	name    string
+	current *follower
+	// file replaced by current, read until its grace period expires
+	prev  *follower
+	until time.Time
+}
+
+// Next appends to buf the next complete record of r. It returns nil when no
+// record is available yet. When the current file has no complete record and
+// a newer file exists, r switches to the newer file but keeps reading the old
+// one for some time so that packets still appended to it are not lost.
+func (r *root) Next(buf []byte) ([]byte, error) {
+	if r.prev != nil {
+		rec, err := r.prev.Read(buf)
+		if rec != nil || err != nil {
+			return rec, err
+		}
+		if time.Now().After(r.until) {
+			r.prev.Close()
+			r.prev = nil
+		}
+	}
+	for {
+		if r.current == nil {
+			next, err := r.next()
+			if err != nil || next == "" {
+				return nil, err
+			}
+			if r.current, err = follow(next); err != nil {
+				return nil, err
+			}
+			r.name = next
+		}
+		rec, err := r.current.Read(buf)
+		if rec != nil || err != nil {
+			return rec, err
+		}
+		next, err := r.next()
+		if err != nil || next == "" {
+			return nil, err
+		}
+		f, err := follow(next)
+		if err != nil {
+			return nil, err
+		}
+		if r.prev != nil {
+			r.prev.Close()
+		}
+		r.prev, r.until = r.current, time.Now().Add(rotationGrace)
+		r.current, r.name = f, next
+	}
+}
+
+func (r *root) Close() error {
+	for _, f := range []*follower{r.prev, r.current} {
+		if f != nil {
+			f.Close()
+		}
+	}
+	r.prev, r.current = nil, nil
+	return nil
+}
+
+// follower reads the records of a file as they are written.
+type follower struct {
+	*os.File
+	offset int64
+}
+
+func follow(file string) (*follower, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	return &follower{File: f}, nil
+}
+
+// Read appends the record found at the current offset to buf if it has been
+// fully written. A record whose size can not fit in the buffer of a decoder is
+// an error: the size is corrupted and waiting for the rest of the record would
+// block forever.
+func (f *follower) Read(buf []byte) ([]byte, error) {
+	i, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	avail := i.Size() - f.offset
+	if avail < 4 {
+		return nil, nil
+	}
+	var size [4]byte
+	if _, err := f.ReadAt(size[:], f.offset); err != nil {
+		return nil, err
+	}
+	n := 4 + int64(binary.LittleEndian.Uint32(size[:]))
+	if n > vmu.BufferSize {
+		return nil, fmt.Errorf("%s: invalid record size %d at offset %d", f.Name(), n-4, f.offset)
+	}
+	if n > avail {
+		return nil, nil
+	}
+	buf = append(buf, make([]byte, n)...)
+	if _, err := f.ReadAt(buf[len(buf)-int(n):], f.offset); err != nil {
+		return nil, err
+	}
+	f.offset += n
+	return buf, nil
+}
+
+var errFound = errors.New("found")
+
+// next returns the first file of the root, in name order, coming after the
+// current file. Directories sorting before the directory of the current file
+// are not walked again.
+func (r *root) next() (string, error) {
+	if !r.dir {
+		if r.name == "" {
+			return r.base, nil
+		}
+		return "", nil
+	}
+	var found string
+	err := filepath.Walk(r.base, func(p string, i os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if i.IsDir() {
+			if p != r.base && p < r.name && !strings.HasPrefix(r.name, p+string(filepath.Separator)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if i.Mode().IsRegular() && p > r.name && !strings.HasSuffix(p, vmu.IndexExt) {
+			found = p
+			return errFound
+		}
+		return nil
+	})
+	if err != nil && err != errFound {
+		return "", err
+	}
+	return found, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTailFollowsRotatedFiles(t *testing.T) {
+	dir := t.TempDir()
+	var (
+		first  = filepath.Join(dir, "2019", "001", "rt_00_04.dat")
+		second = filepath.Join(dir, "2019", "001", "rt_05_09.dat")
+		other  = filepath.Join(t.TempDir(), "rt.dat")
+	)
+	appendRecords(t, first, 1, 2)
+	appendRecords(t, other)
+
+	mr, err := browse([]string{dir, other}, true, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mr.Close()
+
+	got := make(chan byte)
+	go func() {
+		defer close(got)
+		buf := make([]byte, 5)
+		for {
+			if _, err := io.ReadFull(mr, buf); err != nil {
+				return
+			}
+			got <- buf[4]
+		}
+	}()
+	// expect checks that the records ids are read, in any order.
+	expect := func(ids ...byte) {
+		t.Helper()
+		want := make(map[byte]bool)
+		for _, id := range ids {
+			want[id] = true
+		}
+		for range ids {
+			select {
+			case g := <-got:
+				if !want[g] {
+					t.Fatalf("unexpected record %d", g)
+				}
+				delete(want, g)
+			case <-time.After(time.Second):
+				t.Fatalf("records %v not read", want)
+			}
+		}
+	}
+	expect(1)
+	expect(2)
+
+	// a record written in two steps is only read once complete
+	f, err := os.OpenFile(first, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{1, 0})
+	time.Sleep(10 * time.Millisecond)
+	f.Write([]byte{0, 0, 3})
+	f.Close()
+	expect(3)
+
+	// records appended to the old file after the rotation are not lost
+	appendRecords(t, second, 5)
+	time.Sleep(10 * time.Millisecond)
+	appendRecords(t, first, 4)
+	expect(4, 5)
+
+	appendRecords(t, other, 6)
+	appendRecords(t, second, 7)
+	expect(6, 7)
+}
+
+func TestFollowerRecordSize(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rt.dat")
+	appendRecords(t, file, 1)
+
+	f, err := follow(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if rec, err := f.Read(nil); err != nil || len(rec) != 5 || rec[4] != 1 {
+		t.Fatalf("first record: got %v (%v)", rec, err)
+	}
+
+	// a truncated record is not read until complete
+	w, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.Write([]byte{2, 0, 0, 0, 2})
+	if rec, err := f.Read(nil); rec != nil || err != nil {
+		t.Fatalf("truncated record: got %v (%v)", rec, err)
+	}
+	w.Write([]byte{2})
+	if rec, err := f.Read(nil); err != nil || len(rec) != 6 {
+		t.Fatalf("completed record: got %v (%v)", rec, err)
+	}
+
+	// a corrupted size larger than any record is an error
+	w.Write([]byte{0xFF, 0xFF, 0xFF, 0xFF, 3})
+	if rec, err := f.Read(nil); err == nil {
+		t.Fatalf("corrupted size: got %v without error", rec)
+	}
+}
+
+// appendRecords appends to file records of one byte holding ids.
+func appendRecords(t *testing.T, file string, ids ...byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, id := range ids {
+		var rec [5]byte
+		binary.LittleEndian.PutUint32(rec[:], 1)
+		rec[4] = id
+		if _, err := f.Write(rec[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
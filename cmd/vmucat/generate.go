@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/busoc/vmu"
+	"github.com/busoc/vmu/vmutest"
+	"github.com/midbel/cli"
+)
+
+func runGenerate(cmd *cli.Command, args []string) error {
+	cfg := vmutest.Default()
+	cmd.Flag.IntVar(&cfg.Count, "n", cfg.Count, "number of packets")
+	cmd.Flag.DurationVar(&cfg.Interval, "d", cfg.Interval, "interval between packets")
+	cmd.Flag.IntVar(&cfg.Width, "x", cfg.Width, "width of images")
+	cmd.Flag.IntVar(&cfg.Height, "y", cfg.Height, "height of images")
+	cmd.Flag.IntVar(&cfg.Size, "z", cfg.Size, "size of science payloads")
+	cmd.Flag.Float64Var(&cfg.Playback, "playback", 0, "probability of replaying a lost packet as a playback packet")
+	cmd.Flag.Float64Var(&cfg.Gaps, "gaps", 0, "probability of lost packets after a packet")
+	cmd.Flag.Float64Var(&cfg.Resets, "resets", 0, "probability of counter resets")
+	cmd.Flag.Float64Var(&cfg.Invalid, "invalid", 0, "probability of bad checksums")
+	cmd.Flag.Float64Var(&cfg.Truncated, "truncated", 0, "probability of truncated packets")
+	cmd.Flag.BoolVar(&cfg.HRDL, "hrdl", false, "write bare HRDL frames")
+	cmd.Flag.Int64Var(&cfg.Seed, "seed", cfg.Seed, "random seed")
+	start := cmd.Flag.String("t", cfg.Start.Format(time.RFC3339), "time of the first packet")
+	channels := cmd.Flag.String("c", "vic1,vic2,lrsd", "channels")
+	types := cmd.Flag.String("i", "", "image types (default all)")
+	upis := cmd.Flag.String("upi", strings.Join(cfg.UPIs, ","), "UPIs of science packets")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	if cmd.Flag.NArg() != 1 {
+		return fmt.Errorf("no output directory given")
+	}
+
+	var err error
+	if cfg.Start, err = time.Parse(time.RFC3339, *start); err != nil {
+		return err
+	}
+	if cfg.Channels, err = parseChannels(*channels); err != nil {
+		return err
+	}
+	if cfg.Types, err = parseTypes(*types); err != nil {
+		return err
+	}
+	cfg.UPIs = strings.Split(*upis, ",")
+
+	return vmutest.New(cfg).WriteArchive(cmd.Flag.Arg(0))
+}
+
+func parseChannels(str string) ([]uint8, error) {
+	var cs []uint8
+	for _, s := range strings.Split(strings.ToLower(str), ",") {
+		switch s = strings.TrimSpace(s); s {
+		case string(vmu.ChanVic1):
+			cs = append(cs, vmu.VIC1)
+		case string(vmu.ChanVic2):
+			cs = append(cs, vmu.VIC2)
+		case string(vmu.ChanLRSD):
+			cs = append(cs, vmu.LRSD)
+		default:
+			return nil, fmt.Errorf("unknown channel %s", s)
+		}
+	}
+	return cs, nil
+}
+
+// parseTypes accepts image types by name (gray, rgb, jpg...) or by number.
+func parseTypes(str string) ([]vmu.ImageType, error) {
+	if str == "" {
+		return vmutest.AllTypes, nil
+	}
+	var ts []vmu.ImageType
+	for _, s := range strings.Split(strings.ToLower(str), ",") {
+		s = strings.TrimSpace(s)
+		var found bool
+		for _, t := range vmutest.AllTypes {
+			if t.String() == s || strconv.Itoa(int(t)) == s {
+				ts, found = append(ts, t), true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown image type %s", s)
+		}
+	}
+	return ts, nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/busoc/vmu/vmutest"
+)
+
+var update = flag.Bool("update", false, "update the golden files of testdata/golden")
+
+// mainEnv set in the environment makes the test binary run the vmucat command
+// given in its arguments instead of the tests.
+const mainEnv = "VMUCAT_TEST_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(mainEnv) != "" {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+func runCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "no command given")
+		return 2
+	}
+	for _, c := range commands {
+		if name := strings.Fields(c.Usage)[0]; name != args[0] {
+			continue
+		}
+		if err := c.Run(c, args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "%s: unknown command\n", args[0])
+	return 2
+}
+
+// In the arguments of the golden commands, {data} is replaced by the directory
+// of the generated archives, {files} by its files and {out} by a directory
+// whose files are listed with their checksums after the command.
+var goldens = []struct {
+	Name string
+	Args []string
+}{
+	{Name: "list.txt", Args: []string{"list", "{data}"}},
+	{Name: "list.csv", Args: []string{"list", "-c", "{data}"}},
+	{Name: "list-invalid.txt", Args: []string{"list", "-e", "{data}"}},
+	{Name: "count.txt", Args: []string{"count", "{data}"}},
+	{Name: "count.csv", Args: []string{"count", "-c", "-b", "channel,origin", "{data}"}},
+	{Name: "count.json", Args: []string{"count", "-j", "-b", "channel,origin,upi", "-total", "{data}"}},
+	{Name: "count-interval.csv", Args: []string{"count", "-c", "-i", "10s", "{data}"}},
+	{Name: "diff.txt", Args: []string{"diff", "{data}"}},
+	{Name: "diff.csv", Args: []string{"diff", "-c", "-b", "origin", "{data}"}},
+	{Name: "diff.json", Args: []string{"diff", "-j", "-b", "channel,origin", "-total", "{data}"}},
+	{Name: "take.txt", Args: []string{"take", "-r", "upi", "-t", "{prefix}_{index:%06d}_{sequence:%04d}.dat", "{out}", "{data}"}},
+	{Name: "merge.txt", Args: []string{"merge", "-u", "-r", "{out}/merge.log", "{out}/merged.dat", "{files}"}},
+	{Name: "extract.txt", Args: []string{"extract", "-d", "{out}", "-f", "png", "{data}"}},
+}
+
+func TestGolden(t *testing.T) {
+	data := generateArchives(t)
+	files, err := listFiles([]string{data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+
+	dir := filepath.Join("testdata", "golden")
+	if *update {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, g := range goldens {
+		out := t.TempDir()
+		var args []string
+		for _, a := range g.Args {
+			switch a {
+			case "{data}":
+				args = append(args, data)
+			case "{files}":
+				args = append(args, files...)
+			default:
+				args = append(args, strings.ReplaceAll(a, "{out}", out))
+			}
+		}
+		got, stderr := runGolden(t, args, out)
+		got = bytes.ReplaceAll(got, []byte(data), []byte("{data}"))
+		got = bytes.ReplaceAll(got, []byte(out), []byte("{out}"))
+
+		file := filepath.Join(dir, g.Name)
+		if *update {
+			if err := os.WriteFile(file, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("%s: %s (run go test -update first)", g.Name, err)
+		}
+		if !bytes.Equal(got, want) {
+			i, gl, wl := firstDiff(got, want)
+			t.Errorf("%s: output of %s differs from golden file at line %d\ngot:  %s\nwant: %s\nstderr:\n%s", g.Name, strings.Join(g.Args, " "), i, gl, wl, stderr)
+		}
+	}
+}
+
+// generateArchives writes two archives in the rt layout: the first one with
+// playback packets, gaps, resets and bad packets and the second one shifted by
+// 15 seconds with gaps.
+func generateArchives(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+
+	cfg := vmutest.Default()
+	cfg.Count, cfg.Seed = 300, 1
+	cfg.Playback, cfg.Gaps, cfg.Resets, cfg.Invalid = 0.1, 0.05, 0.01, 0.02
+	if err := vmutest.New(cfg).WriteArchive(filepath.Join(dir, "a")); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg = vmutest.Default()
+	cfg.Count, cfg.Seed = 300, 2
+	cfg.Start = cfg.Start.Add(15 * time.Second)
+	cfg.Gaps = 0.05
+	if err := vmutest.New(cfg).WriteArchive(filepath.Join(dir, "b")); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// runGolden runs a vmucat command and returns its standard output followed by
+// its exit status when it fails and by the checksums of the files written in
+// out.
+func runGolden(t *testing.T, args []string, out string) ([]byte, []byte) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), mainEnv+"=1")
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		e, ok := err.(*exec.ExitError)
+		if !ok {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&stdout, "exit status %d\n", e.ExitCode())
+	}
+	err := filepath.WalkDir(out, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		buf, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(out, file)
+		fmt.Fprintf(&stdout, "%x  %s\n", md5.Sum(buf), filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stdout.Bytes(), stderr.Bytes()
+}
+
+// firstDiff returns the number of the first line that differs in got and want
+// and the two lines.
+func firstDiff(got, want []byte) (int, []byte, []byte) {
+	gs, ws := bytes.Split(got, []byte("\n")), bytes.Split(want, []byte("\n"))
+	for i := 0; ; i++ {
+		var g, w []byte
+		if i < len(gs) {
+			g = gs[i]
+		}
+		if i < len(ws) {
+			w = ws[i]
+		}
+		if !bytes.Equal(g, w) || i >= len(gs) || i >= len(ws) {
+			return i + 1, g, w
+		}
+	}
+}
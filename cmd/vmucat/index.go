@@ -0,0 +1,220 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/busoc/rt"
+	"github.com/busoc/vmu"
+	"github.com/midbel/cli"
+)
+
+func runIndex(cmd *cli.Command, args []string) error {
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	files, err := listFiles(cmd.Flag.Args())
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		x, err := vmu.BuildIndex(f)
+		if err != nil {
+			return err
+		}
+		if err := x.Save(); err != nil {
+			return err
+		}
+		log.Printf("%s: %d packets indexed", f, len(x.Entries))
+	}
+	return nil
+}
+
+// query holds the flags used to select packets with an index.
+type query struct {
+	Channel int
+	Origin  int
+	Starts  timeValue
+	Ends    timeValue
+	First   counterValue
+	Last    counterValue
+	UPI     string
+}
+
+func (q *query) Register(fs *flag.FlagSet) {
+	fs.IntVar(&q.Origin, "o", 0, "origin")
+	fs.Var(&q.Starts, "starts", "first acquisition time")
+	fs.Var(&q.Ends, "ends", "last acquisition time")
+	fs.Var(&q.First, "first", "first origin counter")
+	fs.Var(&q.Last, "last", "last origin counter")
+	fs.StringVar(&q.UPI, "upi", "", "upi")
+}
+
+func (q *query) Query() vmu.Query {
+	return vmu.Query{
+		Channel: uint8(q.Channel),
+		Origin:  uint8(q.Origin),
+		Starts:  time.Time(q.Starts),
+		Ends:    time.Time(q.Ends),
+		First:   q.First.Counter,
+		Last:    q.Last.Counter,
+		UPI:     q.UPI,
+	}
+}
+
+// openArchives returns a reader over the archives found in args. When q
+// selects packets, only the packets that the up to date index of an archive
+// gives as matching q are read from it: archives without index, or with a
+// stale one, are read entirely.
+func openArchives(args []string, q vmu.Query) (io.ReadCloser, error) {
+	files, err := listFiles(args)
+	if err != nil {
+		return nil, err
+	}
+	return &archiveReader{files: files, query: q}, nil
+}
+
+type archiveReader struct {
+	files []string
+	query vmu.Query
+
+	file *os.File
+	curr io.Reader
+}
+
+func (a *archiveReader) Read(bs []byte) (int, error) {
+	for {
+		if a.curr == nil {
+			if len(a.files) == 0 {
+				return 0, io.EOF
+			}
+			if err := a.open(a.files[0]); err != nil {
+				return 0, err
+			}
+			a.files = a.files[1:]
+		}
+		n, err := a.curr.Read(bs)
+		if err == io.EOF {
+			a.Close()
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (a *archiveReader) Close() error {
+	var err error
+	if a.file != nil {
+		err = a.file.Close()
+	}
+	a.file, a.curr = nil, nil
+	return err
+}
+
+// open opens file and seeks to the packets matching the query of a. Entries
+// following each other in the file are read at once.
+func (a *archiveReader) open(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	a.file, a.curr = f, f
+	if a.query.IsZero() {
+		return nil
+	}
+	x, err := vmu.LoadIndex(file)
+	if err != nil {
+		return nil
+	}
+	var (
+		rs []io.Reader
+		es = x.Find(a.query)
+	)
+	for i := 0; i < len(es); {
+		offset, end := es[i].Offset, es[i].Offset+int64(es[i].Length)
+		for i++; i < len(es) && es[i].Offset == end; i++ {
+			end += int64(es[i].Length)
+		}
+		rs = append(rs, io.NewSectionReader(f, offset, end-offset))
+	}
+	a.curr = io.MultiReader(rs...)
+	return nil
+}
+
+// browseArchives returns a reader over the archives found in args, ignoring
+// index files.
+func browseArchives(args []string) (io.ReadCloser, error) {
+	files, err := listFiles(args)
+	if err != nil {
+		return nil, err
+	}
+	return rt.Browse(files, true)
+}
+
+// listFiles returns the archives found in args, ignoring index files.
+func listFiles(args []string) ([]string, error) {
+	var files []string
+	for _, a := range args {
+		err := filepath.Walk(a, func(p string, i os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if i.Mode().IsRegular() && !strings.HasSuffix(p, vmu.IndexExt) {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// counterValue is an origin counter given on the command line. Counter is nil
+// when the flag is not set.
+type counterValue struct {
+	Counter *uint32
+}
+
+func (c *counterValue) Set(str string) error {
+	n, err := strconv.ParseUint(str, 0, 32)
+	if err == nil {
+		v := uint32(n)
+		c.Counter = &v
+	}
+	return err
+}
+
+func (c *counterValue) String() string {
+	if c == nil || c.Counter == nil {
+		return ""
+	}
+	return strconv.FormatUint(uint64(*c.Counter), 10)
+}
+
+type timeValue time.Time
+
+func (t *timeValue) Set(str string) error {
+	w, err := time.Parse(time.RFC3339, str)
+	if err == nil {
+		*t = timeValue(w)
+	}
+	return err
+}
+
+func (t *timeValue) String() string {
+	if t == nil || time.Time(*t).IsZero() {
+		return ""
+	}
+	return time.Time(*t).Format(time.RFC3339)
+}
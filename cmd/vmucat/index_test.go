@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/busoc/rt"
+	"github.com/busoc/vmu"
+	"github.com/busoc/vmu/vmutest"
+)
+
+func TestOpenArchivesSeeksMatchingPackets(t *testing.T) {
+	cfg := vmutest.Default()
+	cfg.Count = 60
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "rt_00_04.dat")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vmutest.New(cfg).WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	x, err := vmu.BuildIndex(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := x.Save(); err != nil {
+		t.Fatal(err)
+	}
+	first, last := uint32(5), uint32(9)
+	q := vmu.Query{Channel: vmu.VIC1, First: &first, Last: &last}
+
+	got := readArchives(t, dir, q)
+	if want := len(x.Find(q)); len(got) != want {
+		t.Fatalf("%d packets read, want %d", len(got), want)
+	}
+	for _, p := range got {
+		if !q.Keep(p) {
+			t.Errorf("packet of channel %d and counter %d read", p.VMUHeader.Channel, p.DataHeader.Counter)
+		}
+	}
+
+	// a stale index is ignored and the whole archive is read
+	f, err = os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Count = 1
+	if _, err := vmutest.New(cfg).WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if got := readArchives(t, dir, q); len(got) != len(x.Entries)+1 {
+		t.Fatalf("%d packets read from archive with stale index, want %d", len(got), len(x.Entries)+1)
+	}
+}
+
+func readArchives(t *testing.T, dir string, q vmu.Query) []vmu.Packet {
+	t.Helper()
+	mr, err := openArchives([]string{dir}, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mr.Close()
+
+	var (
+		ps []vmu.Packet
+		r  = rt.NewReader(mr)
+	)
+	buf := make([]byte, vmu.BufferSize)
+	for {
+		n, err := r.Read(buf)
+		if err == io.EOF {
+			return ps
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := vmu.DecodePacket(buf[:n], false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ps = append(ps, p)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/busoc/rt"
+	"github.com/busoc/vmu"
+	"github.com/midbel/cli"
+	"github.com/midbel/linewriter"
+)
+
+// distribution holds the latencies of a group of packets.
+type distribution struct {
+	Delay   []time.Duration // VMU time - acquisition time
+	Archive []time.Duration // HRDP archive time - acquisition time
+}
+
+type jump struct {
+	key
+	When   time.Time
+	Before time.Duration
+	After  time.Duration
+}
+
+func runLatency(cmd *cli.Command, args []string) error {
+	csv := cmd.Flag.Bool("c", false, "csv format")
+	keepInvalid := cmd.Flag.Bool("e", false, "keep invalid packets")
+	by := cmd.Flag.String("b", "channel,origin", "group packets by channel, origin and/or upi")
+	interval := cmd.Flag.Duration("i", 0, "interval")
+	threshold := cmd.Flag.Duration("t", time.Second, "outlier and clock jump threshold")
+	jumps := cmd.Flag.Bool("x", false, "list clock jumps")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	g, err := parseGroup(*by)
+	if err != nil {
+		return err
+	}
+
+	mr, err := browseArchives(cmd.Flag.Args())
+	if err != nil {
+		return err
+	}
+	defer mr.Close()
+
+	var (
+		dists = make(map[key]*distribution)
+		seen  = make(map[key]time.Duration)
+		moves []jump
+	)
+	d := vmu.NewDecoder(rt.NewReader(mr), nil)
+	defer d.Close()
+	for {
+		p, err := d.DecodeView(false)
+		switch err {
+		case nil, vmu.ErrInvalid:
+			if err == vmu.ErrInvalid && !*keepInvalid {
+				continue
+			}
+			k := g.Key(p, 0)
+			delay := p.VMUHeader.Timestamp().Sub(p.DataHeader.Acquisition())
+			if prev, ok := seen[k]; ok && absDuration(delay-prev) > *threshold {
+				moves = append(moves, jump{
+					key:    k,
+					When:   p.VMUHeader.Timestamp(),
+					Before: prev,
+					After:  delay,
+				})
+			}
+			seen[k] = delay
+
+			if *interval > 0 {
+				k.Time = p.VMUHeader.Timestamp().Truncate(*interval)
+			}
+			dist, ok := dists[k]
+			if !ok {
+				dist = &distribution{}
+				dists[k] = dist
+			}
+			dist.Delay = append(dist.Delay, delay)
+			if p.HRDPHeader.Size > 0 {
+				dist.Archive = append(dist.Archive, p.HRDPHeader.Elapsed())
+			}
+		case vmu.ErrSkip:
+		case io.EOF:
+			if *jumps {
+				return writeJumps(os.Stdout, g, moves, *csv)
+			}
+			return writeLatency(os.Stdout, g, dists, *interval > 0, *threshold, *csv)
+		default:
+			return err
+		}
+	}
+}
+
+func writeLatency(w io.Writer, g group, dists map[key]*distribution, bucket bool, threshold time.Duration, csv bool) error {
+	keys := make([]key, 0, len(dists))
+	for k := range dists {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Less(keys[j]) })
+
+	line := Line(csv)
+	for _, k := range keys {
+		dist := dists[k]
+		appendKey(line, g, k)
+		if bucket {
+			line.AppendTime(k.Time, rt.TimeFormat, linewriter.AlignRight)
+		}
+		line.AppendUint(uint64(len(dist.Delay)), 6, linewriter.AlignRight)
+		appendDistribution(line, dist.Delay, threshold)
+		appendDistribution(line, dist.Archive, threshold)
+		if _, err := io.Copy(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendDistribution appends the minimum, the 50th, 90th and 99th
+// percentiles, the maximum and the number of outliers of ds. A value is an
+// outlier when it is further than threshold from the median.
+func appendDistribution(line *linewriter.Writer, ds []time.Duration, threshold time.Duration) {
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+
+	median := percentile(ds, 50)
+	var outliers uint64
+	for _, d := range ds {
+		if absDuration(d-median) > threshold {
+			outliers++
+		}
+	}
+	line.AppendDuration(percentile(ds, 0), 10, linewriter.AlignRight)
+	line.AppendDuration(median, 10, linewriter.AlignRight)
+	line.AppendDuration(percentile(ds, 90), 10, linewriter.AlignRight)
+	line.AppendDuration(percentile(ds, 99), 10, linewriter.AlignRight)
+	line.AppendDuration(percentile(ds, 100), 10, linewriter.AlignRight)
+	line.AppendUint(outliers, 6, linewriter.AlignRight)
+}
+
+func writeJumps(w io.Writer, g group, moves []jump, csv bool) error {
+	sort.SliceStable(moves, func(i, j int) bool {
+		if !moves[i].key.Same(moves[j].key) {
+			return moves[i].key.Less(moves[j].key)
+		}
+		return moves[i].When.Before(moves[j].When)
+	})
+	line := Line(csv)
+	for _, j := range moves {
+		appendKey(line, g, j.key)
+		line.AppendTime(j.When, rt.TimeFormat, linewriter.AlignRight)
+		line.AppendDuration(j.Before, 10, linewriter.AlignRight)
+		line.AppendDuration(j.After, 10, linewriter.AlignRight)
+		line.AppendDuration(j.After-j.Before, 10, linewriter.AlignRight)
+		if _, err := io.Copy(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// percentile returns the p-th percentile of the sorted durations ds.
+func percentile(ds []time.Duration, p int) time.Duration {
+	if len(ds) == 0 {
+		return 0
+	}
+	ix := (len(ds) - 1) * p / 100
+	return ds[ix]
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
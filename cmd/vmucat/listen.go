@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/busoc/vmu"
+	"github.com/midbel/cli"
+	"github.com/midbel/roll"
+)
+
+// sink receives the packets decoded by listen.
+type sink interface {
+	Write(vmu.Packet, bool) error
+	Close() error
+}
+
+func runListen(cmd *cli.Command, args []string) error {
+	var t taker
+	cmd.Flag.StringVar(&t.Prefix, "n", "", "prefix of archive files")
+	cmd.Flag.IntVar(&t.Channel, "i", 0, "channel")
+	cmd.Flag.IntVar(&t.Size, "s", 0, "size")
+	cmd.Flag.IntVar(&t.Count, "k", 0, "count")
+	cmd.Flag.BoolVar(&t.Invalid, "e", false, "keep invalid packets")
+	proto := cmd.Flag.String("p", "udp", "protocol (udp, tcp)")
+	datadir := cmd.Flag.String("d", "", "write packets to rolling archive in datadir")
+	imgdir := cmd.Flag.String("x", "", "export images to directory")
+	format := cmd.Flag.String("f", "png", "image format (png, jpg)")
+	name := cmd.Flag.String("t", vmu.DefaultNaming, "naming template of exported images")
+	csv := cmd.Flag.Bool("c", false, "csv format")
+	verbose := cmd.Flag.Bool("v", false, "dump packets to stdout")
+	metrics := cmd.Flag.String("metrics", "", "expose metrics on address")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	naming, err := vmu.ParseNaming(*name)
+	if err != nil {
+		return err
+	}
+	r, err := vmu.Listen(strings.ToLower(*proto), cmd.Flag.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	var sinks []sink
+	if *datadir != "" {
+		a := &archiveSink{}
+		a.open = func() (*roll.Roller, error) {
+			return roll.Roll(t.Open(*datadir, &a.rolling), roll.WithThreshold(t.Size, t.Count))
+		}
+		sinks = append(sinks, a)
+	}
+	if *imgdir != "" {
+		sinks = append(sinks, exportSink{dir: *imgdir, format: *format, naming: naming})
+	}
+	if *verbose || len(sinks) == 0 {
+		sinks = append(sinks, dumpSink{vmu.Dump(os.Stdout, *csv)})
+	}
+	defer func() {
+		for _, s := range sinks {
+			s.Close()
+		}
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	defer signal.Stop(sig)
+	go func() {
+		<-sig
+		r.Close()
+	}()
+
+	m := serveMetrics(*metrics)
+	d := vmu.NewDecoder(r, vmu.WithChannel(t.Channel, !t.Invalid))
+	defer d.Close()
+	for {
+		switch p, err := d.DecodeView(true); err {
+		case nil, vmu.ErrInvalid:
+			m.Update(p, err)
+			if err == vmu.ErrInvalid && !t.Invalid {
+				continue
+			}
+			if t.Channel > 0 && p.VMUHeader.Channel != uint8(t.Channel) {
+				continue
+			}
+			for _, s := range sinks {
+				if err := s.Write(p, err == nil); err != nil {
+					return err
+				}
+			}
+		case vmu.ErrSkip:
+			m.Skip()
+		case io.EOF:
+			return nil
+		default:
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// archiveSink writes packets to a rolling archive. The archive is created with
+// the first packet so that the name of its first file is given by a packet.
+type archiveSink struct {
+	rolling
+	open func() (*roll.Roller, error)
+}
+
+func (a *archiveSink) Write(p vmu.Packet, _ bool) error {
+	buf, err := p.Marshal()
+	if err != nil {
+		return nil
+	}
+	a.Set(p)
+	if a.Roller == nil {
+		if a.Roller, err = a.open(); err != nil {
+			return err
+		}
+	}
+	_, err = a.Roller.Write(buf)
+	return err
+}
+
+func (a *archiveSink) Close() error {
+	if a.Roller == nil {
+		return nil
+	}
+	return a.Roller.Close()
+}
+
+type dumpSink struct {
+	*vmu.Dumper
+}
+
+func (d dumpSink) Write(p vmu.Packet, valid bool) error {
+	return d.DumpPacket(p, valid)
+}
+
+func (d dumpSink) Close() error {
+	return nil
+}
+
+type exportSink struct {
+	dir    string
+	format string
+	naming *vmu.Naming
+}
+
+func (e exportSink) Write(p vmu.Packet, valid bool) error {
+	if !valid || p.VMUHeader.Channel == vmu.LRSD {
+		return nil
+	}
+	ext := e.format
+	if t := p.DataHeader.Type; t == vmu.JPEG || t == vmu.PNG {
+		ext = t.String()
+	}
+	name := e.naming.Filename(p, ext)
+
+	file := filepath.Join(e.dir, name)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	w, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if err := p.Export(w, e.format); err != nil {
+		log.Printf("%s: %s", name, err)
+		os.Remove(file)
+	}
+	return nil
+}
+
+func (e exportSink) Close() error {
+	return nil
+}
@@ -7,34 +7,89 @@ import (
 
 var commands = []*cli.Command{
 	{
-		Usage: "list [-e with-errors] [-c csv] <file...>",
+		Usage: "list [-e with-errors] [-c csv] [-follow] [-every interval] [-metrics address] [-i channel] [-o origin] [-upi upi] [-starts time] [-ends time] [-first counter] [-last counter] <file...>",
 		Short: "",
 		Run:   runList,
 	},
 	{
-		Usage: "diff [-e with-errors] [-b by] [-c csv] [-d duration] <file...>",
+		Usage: "diff [-e with-errors] [-b by] [-c csv] [-j json] [-s sparkline] [-sort field] [-total] [-d duration] [-i interval] [-follow] [-metrics address] <file...>",
 		Short: "",
 		Run:   runDiff,
 	},
 	{
-		Usage: "count [-e with-errors] [-b by] [-c csv] <file...>",
+		Usage: "count [-e with-errors] [-b by] [-c csv] [-j json] [-s sparkline] [-sort field] [-total] [-i interval] [-w summary] [-follow] [-metrics address] <file...>",
 		Short: "",
 		Run:   runCount,
 	},
 	{
-		Usage: "take [-e with-errors] [-i channel] [-d datadir] <file...>",
+		Usage: "latency [-e with-errors] [-b by] [-c csv] [-i interval] [-t threshold] [-x jumps] <file...>",
+		Short: "report latency between VMU, acquisition and archive times",
+		Run:   runLatency,
+	},
+	{
+		Usage: "reconcile [-e with-errors] [-c csv] [-w file] [-window duration] <file...>",
+		Short: "fill gaps of realtime streams with playback packets",
+		Run:   runReconcile,
+	},
+	{
+		Usage: "listen [-p protocol] [-d datadir] [-x imgdir] [-f format] [-t template] [-v verbose] [-c csv] [-e with-errors] [-i channel] [-metrics address] <address>",
+		Short: "decode packets received live over udp or tcp",
+		Run:   runListen,
+	},
+	{
+		Usage: "replay [-p protocol] [-x speed] [-t clock] [-l loop] [-i channel] [-o origin] [-drop p] [-corrupt p] [-reorder p] <address> <file...>",
+		Short: "send packets of archives to address with their original timing",
+		Run:   runReplay,
+	},
+	{
+		Usage: "serve [-a address] <datadir>",
+		Short: "browse archives of datadir with a web browser",
+		Run:   runServe,
+	},
+	{
+		Usage: "take [-e with-errors] [-i channel] [-d interval] [-n prefix] [-s size] [-c count] [-r route] [-t template] <datadir> <file...>",
 		Short: "",
 		Run:   runTake,
 	},
 	{
-		Usage: "merge <final> <file...>",
+		Usage: "merge [-u dedup] [-r report] [-f framing] <final> <file...>",
 		Short: "merge and reorder packets from multiple files",
 		Run:   runMerge,
 	},
 	{
-		Usage: "extract [-d datadir] [-e with-errors] [-c channel] <file...>",
-		Short: "",
-		Run:   nil,
+		Usage: "repair [-r report] [-f framing] <final> <file>",
+		Short: "rebuild a damaged archive with its recoverable packets",
+		Run:   runRepair,
+	},
+	{
+		Usage: "diagnose [-c csv] [-a attributed] <file...>",
+		Short: "report the likely cause of bad packets and their recovered headers",
+		Run:   runDiagnose,
+	},
+	{
+		Usage: "extract [-d datadir] [-e with-errors] [-c channel] [-f format] [-s schemas] [-r route] [-t template] [-o origin] [-upi upi] [-starts time] [-ends time] [-first counter] [-last counter] <file...>",
+		Short: "write the payload of packets to files",
+		Run:   runExtract,
+	},
+	{
+		Usage: "science [-s schemas] [-f format] [-e with-errors] [-o origin] [-upi upi] [-starts time] [-ends time] [-first counter] [-last counter] <file...>",
+		Short: "export science data decoded with schemas as time series",
+		Run:   runScience,
+	},
+	{
+		Usage: "assemble [-d datadir] [-w window] [-e with-errors] [-t template] [-o origin] [-upi upi] [-starts time] [-ends time] [-first counter] [-last counter] <file...>",
+		Short: "reassemble science products split across packets",
+		Run:   runAssemble,
+	},
+	{
+		Usage: "generate [-n count] [-d interval] [-t time] [-c channels] [-i types] [-upi upis] [-x width] [-y height] [-z size] [-playback p] [-gaps p] [-resets p] [-invalid p] [-truncated p] [-hrdl] [-seed seed] <dir>",
+		Short: "write an rt archive of synthetic packets in dir",
+		Run:   runGenerate,
+	},
+	{
+		Usage: "index <file...>",
+		Short: "build the index of archive files",
+		Run:   runIndex,
 	},
 }
 
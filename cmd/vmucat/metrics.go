@@ -0,0 +1,25 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/busoc/vmu"
+)
+
+// serveMetrics exposes new metrics on addr in the Prometheus text format. It
+// returns nil when addr is empty.
+func serveMetrics(addr string) *vmu.Metrics {
+	if addr == "" {
+		return nil
+	}
+	m := vmu.NewMetrics()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("metrics: %s", err)
+		}
+	}()
+	return m
+}
@@ -0,0 +1,528 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/busoc/rt"
+	"github.com/busoc/vmu"
+	"github.com/midbel/linewriter"
+)
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// output holds the options shared by the reports of vmucat.
+type output struct {
+	CSV   bool
+	JSON  bool
+	Spark bool
+	Total bool
+	Sort  string
+}
+
+type gap struct {
+	key
+	vmu.Gap
+}
+
+// Rows writes rows to w. Rows are expected to be ordered by group and time
+// and are reordered when a sort field has been given. interval is the span of
+// the buckets of the rows, if any.
+func (o output) Rows(w io.Writer, g group, rows []row, interval time.Duration) error {
+	if o.Spark {
+		return o.sparklines(w, g, rows, interval)
+	}
+	if err := sortRows(rows, o.Sort); err != nil {
+		return err
+	}
+	var (
+		total  coze
+		bucket = interval > 0
+	)
+	if o.Total {
+		for _, r := range rows {
+			total.Add(r.coze)
+		}
+	}
+	if o.JSON {
+		return writeJSON(w, g, rows, bucket, o.Total, total)
+	}
+	line := Line(o.CSV)
+	for _, r := range rows {
+		appendKey(line, g, r.key)
+		if bucket {
+			line.AppendTime(r.Time, rt.TimeFormat, linewriter.AlignRight)
+		}
+		o.appendCoze(line, r.coze)
+		if _, err := io.Copy(w, line); err != nil {
+			return err
+		}
+	}
+	if o.Total {
+		appendTotal(line, g, bucket)
+		o.appendCoze(line, total)
+		if _, err := io.Copy(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o output) appendCoze(line *linewriter.Writer, cz coze) {
+	line.AppendUint(cz.Count, 6, linewriter.AlignRight)
+	line.AppendUint(cz.Missing, 6, linewriter.AlignRight)
+	line.AppendUint(cz.Error, 6, linewriter.AlignRight)
+	line.AppendUint(cz.Resets, 6, linewriter.AlignRight)
+	if o.CSV {
+		line.AppendUint(cz.Size, 8, linewriter.AlignRight)
+	} else {
+		line.AppendSize(int64(cz.Size), 8, linewriter.AlignRight)
+	}
+	line.AppendUint(cz.First, 8, linewriter.AlignRight)
+	line.AppendTime(cz.StartTime, rt.TimeFormat, linewriter.AlignRight)
+	line.AppendUint(cz.Last, 8, linewriter.AlignRight)
+	line.AppendTime(cz.EndTime, rt.TimeFormat, linewriter.AlignRight)
+	line.AppendString(cz.Breakdown(), 24, linewriter.Text|linewriter.AlignLeft)
+}
+
+// Gaps writes the gaps found by diff to w, ordered by group and start time
+// unless a sort field has been given.
+func (o output) Gaps(w io.Writer, g group, gaps []gap) error {
+	if err := sortGaps(gaps, o.Sort); err != nil {
+		return err
+	}
+	var total gapTotal
+	for _, p := range gaps {
+		total.Add(p)
+	}
+	if o.JSON {
+		return writeJSONGaps(w, g, gaps, o.Total, total)
+	}
+	line := Line(o.CSV)
+	for _, p := range gaps {
+		appendKey(line, g, p.key)
+		line.AppendTime(p.Starts, rt.TimeFormat, linewriter.AlignRight)
+		line.AppendTime(p.Ends, rt.TimeFormat, linewriter.AlignRight)
+		line.AppendUint(uint64(p.Last), 8, linewriter.AlignRight)
+		line.AppendUint(uint64(p.First), 8, linewriter.AlignRight)
+		line.AppendUint(uint64(p.Missing()), 8, linewriter.AlignRight)
+		line.AppendDuration(p.Duration(), 10, linewriter.AlignRight)
+		line.AppendString(p.Kind.String(), 6, linewriter.Text|linewriter.AlignLeft)
+		if _, err := io.Copy(w, line); err != nil {
+			return err
+		}
+	}
+	if o.Total {
+		// footer cells follow the columns of the rows: the counters of the
+		// last and first packets have no total and are left empty.
+		appendTotal(line, g, false)
+		line.AppendTime(total.Starts, rt.TimeFormat, linewriter.AlignRight)
+		line.AppendTime(total.Ends, rt.TimeFormat, linewriter.AlignRight)
+		line.AppendString("", 8, linewriter.Text|linewriter.AlignRight)
+		line.AppendString("", 8, linewriter.Text|linewriter.AlignRight)
+		line.AppendUint(total.Missing, 8, linewriter.AlignRight)
+		line.AppendDuration(total.Duration, 10, linewriter.AlignRight)
+		line.AppendString("", 6, linewriter.Text|linewriter.AlignLeft)
+		if _, err := io.Copy(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gapTotal sums the gaps reported by diff.
+type gapTotal struct {
+	Count    int
+	Resets   int
+	Missing  uint64
+	Duration time.Duration
+	Starts   time.Time
+	Ends     time.Time
+}
+
+func (t *gapTotal) Add(g gap) {
+	t.Count++
+	t.Missing += uint64(g.Missing())
+	t.Duration += g.Duration()
+	if g.Kind == vmu.GapReset {
+		t.Resets++
+	}
+	if t.Starts.IsZero() || g.Starts.Before(t.Starts) {
+		t.Starts = g.Starts
+	}
+	if g.Ends.After(t.Ends) {
+		t.Ends = g.Ends
+	}
+}
+
+func appendKey(line *linewriter.Writer, g group, k key) {
+	if g.Has(groupChannel) {
+		line.AppendBytes(vmu.WhichChannel(k.Channel), 4, linewriter.Text|linewriter.AlignLeft)
+	}
+	if g.Has(groupOrigin) {
+		line.AppendUint(uint64(k.Origin), 2, linewriter.AlignCenter|linewriter.Hex|linewriter.WithZero)
+	}
+	if g.Has(groupUPI) {
+		line.AppendString(k.UPI, 16, linewriter.Text|linewriter.AlignLeft)
+	}
+}
+
+// appendTotal fills the key columns of the footer row.
+func appendTotal(line *linewriter.Writer, g group, bucket bool) {
+	label := "total"
+	for _, f := range []group{groupChannel, groupOrigin, groupUPI} {
+		if g.Has(f) {
+			line.AppendString(label, 5, linewriter.Text|linewriter.AlignLeft)
+			label = ""
+		}
+	}
+	if bucket {
+		line.AppendString("", len(rt.TimeFormat), linewriter.Text|linewriter.AlignLeft)
+	}
+}
+
+type jsonRow struct {
+	Channel string     `json:"channel,omitempty"`
+	Origin  *uint8     `json:"origin,omitempty"`
+	UPI     string     `json:"upi,omitempty"`
+	Time    *time.Time `json:"time,omitempty"`
+	Count   uint64     `json:"count"`
+	Missing uint64     `json:"missing"`
+	Error   uint64     `json:"errors"`
+	Resets  uint64     `json:"resets"`
+	Size    uint64     `json:"bytes"`
+	First   uint64     `json:"first"`
+	Last    uint64     `json:"last"`
+	Starts  time.Time  `json:"starts"`
+	Ends    time.Time  `json:"ends"`
+	Types   string     `json:"types,omitempty"`
+}
+
+func makeJSONRow(cz coze) jsonRow {
+	return jsonRow{
+		Count:   cz.Count,
+		Missing: cz.Missing,
+		Error:   cz.Error,
+		Resets:  cz.Resets,
+		Size:    cz.Size,
+		First:   cz.First,
+		Last:    cz.Last,
+		Starts:  cz.StartTime,
+		Ends:    cz.EndTime,
+		Types:   cz.Breakdown(),
+	}
+}
+
+// writeJSON writes rows as an object with the rows and, with withTotal, the
+// total of all rows so that the shape of the output does not depend on
+// -total.
+func writeJSON(w io.Writer, g group, rows []row, bucket, withTotal bool, total coze) error {
+	items := make([]jsonRow, 0, len(rows))
+	for _, r := range rows {
+		i := makeJSONRow(r.coze)
+		if g.Has(groupChannel) {
+			i.Channel = string(vmu.WhichChannel(r.Channel))
+		}
+		if g.Has(groupOrigin) {
+			origin := r.Origin
+			i.Origin = &origin
+		}
+		if g.Has(groupUPI) {
+			i.UPI = r.UPI
+		}
+		if bucket {
+			when := r.Time
+			i.Time = &when
+		}
+		items = append(items, i)
+	}
+	doc := struct {
+		Rows  []jsonRow `json:"rows"`
+		Total *jsonRow  `json:"total,omitempty"`
+	}{Rows: items}
+	if withTotal {
+		t := makeJSONRow(total)
+		doc.Total = &t
+	}
+	return encodeJSON(w, doc)
+}
+
+type jsonGap struct {
+	Channel  string    `json:"channel,omitempty"`
+	Origin   *uint8    `json:"origin,omitempty"`
+	UPI      string    `json:"upi,omitempty"`
+	Starts   time.Time `json:"starts"`
+	Ends     time.Time `json:"ends"`
+	Last     *uint32   `json:"last,omitempty"`
+	First    *uint32   `json:"first,omitempty"`
+	Missing  uint64    `json:"missing"`
+	Duration string    `json:"duration"`
+	Kind     string    `json:"kind,omitempty"`
+	Count    int       `json:"count,omitempty"`
+	Resets   int       `json:"resets,omitempty"`
+}
+
+// writeJSONGaps writes gaps with the same shape as writeJSON.
+func writeJSONGaps(w io.Writer, g group, gaps []gap, withTotal bool, total gapTotal) error {
+	items := make([]jsonGap, 0, len(gaps))
+	for _, p := range gaps {
+		last, first := p.Last, p.First
+		i := jsonGap{
+			Starts:   p.Starts,
+			Ends:     p.Ends,
+			Last:     &last,
+			First:    &first,
+			Missing:  uint64(p.Missing()),
+			Duration: p.Duration().String(),
+			Kind:     p.Kind.String(),
+		}
+		if g.Has(groupChannel) {
+			i.Channel = string(vmu.WhichChannel(p.Channel))
+		}
+		if g.Has(groupOrigin) {
+			origin := p.Origin
+			i.Origin = &origin
+		}
+		if g.Has(groupUPI) {
+			i.UPI = p.UPI
+		}
+		items = append(items, i)
+	}
+	doc := struct {
+		Rows  []jsonGap `json:"rows"`
+		Total *jsonGap  `json:"total,omitempty"`
+	}{Rows: items}
+	if withTotal {
+		doc.Total = &jsonGap{
+			Starts:   total.Starts,
+			Ends:     total.Ends,
+			Missing:  total.Missing,
+			Duration: total.Duration.String(),
+			Count:    total.Count,
+			Resets:   total.Resets,
+		}
+	}
+	return encodeJSON(w, doc)
+}
+
+func encodeJSON(w io.Writer, v interface{}) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(v)
+}
+
+// sparklines writes one line per group with the count of each bucket. Lines
+// are ordered by the totals of their groups when a sort field has been given
+// and followed by the line of the total of all groups with -total.
+func (o output) sparklines(w io.Writer, g group, rows []row, interval time.Duration) error {
+	var (
+		groups []row
+		spans  = make(map[key][]row)
+	)
+	for i := 0; i < len(rows); {
+		j := i + 1
+		for j < len(rows) && rows[j].key.Same(rows[i].key) {
+			j++
+		}
+		s := row{key: rows[i].key}
+		s.Time = time.Time{}
+		for _, r := range rows[i:j] {
+			s.Add(r.coze)
+		}
+		groups, spans[s.key] = append(groups, s), rows[i:j]
+		i = j
+	}
+	if err := sortRows(groups, o.Sort); err != nil {
+		return err
+	}
+	for _, s := range groups {
+		if err := writeSparkline(w, s.key.Label(g), spans[s.key]); err != nil {
+			return err
+		}
+	}
+	if !o.Total {
+		return nil
+	}
+	totals := make(map[key]coze)
+	for _, r := range rows {
+		k := key{Time: r.Time}
+		cz := totals[k]
+		cz.Add(r.coze)
+		totals[k] = cz
+	}
+	return writeSparkline(w, "total", fillRows(totals, interval))
+}
+
+// writeSparkline writes the count of each bucket of rows scaled between their
+// minimum and maximum counts.
+func writeSparkline(w io.Writer, label string, rows []row) error {
+	var (
+		lo, hi = rows[0].Count, rows[0].Count
+		total  uint64
+	)
+	for _, r := range rows {
+		if r.Count < lo {
+			lo = r.Count
+		}
+		if r.Count > hi {
+			hi = r.Count
+		}
+		total += r.Count
+	}
+	var line strings.Builder
+	for _, r := range rows {
+		var ix uint64
+		if hi > lo {
+			ix = (r.Count - lo) * uint64(len(sparks)-1) / (hi - lo)
+		}
+		line.WriteRune(sparks[ix])
+	}
+	_, err := fmt.Fprintf(w, "%-24s %s min=%d max=%d total=%d\n", label, line.String(), lo, hi, total)
+	return err
+}
+
+// parseOrder splits a sort specification given as field, field:asc,
+// field:desc or -field.
+func parseOrder(str string) (string, bool, error) {
+	str = strings.ToLower(strings.TrimSpace(str))
+	if strings.HasPrefix(str, "-") {
+		return str[1:], true, nil
+	}
+	field, dir := str, ""
+	if ix := strings.Index(str, ":"); ix >= 0 {
+		field, dir = str[:ix], str[ix+1:]
+	}
+	switch dir {
+	case "", "asc":
+		return field, false, nil
+	case "desc":
+		return field, true, nil
+	default:
+		return "", false, fmt.Errorf("unknown sort direction %s", dir)
+	}
+}
+
+func sortRows(rows []row, spec string) error {
+	if spec == "" {
+		return nil
+	}
+	field, desc, err := parseOrder(spec)
+	if err != nil {
+		return err
+	}
+	var cmp func(a, b row) int
+	switch field {
+	case Channel, Origin, UPI, "time":
+		cmp = func(a, b row) int { return compareKey(a.key, b.key, field) }
+	case "count":
+		cmp = func(a, b row) int { return compareUint(a.Count, b.Count) }
+	case "missing":
+		cmp = func(a, b row) int { return compareUint(a.Missing, b.Missing) }
+	case "errors":
+		cmp = func(a, b row) int { return compareUint(a.Error, b.Error) }
+	case "resets":
+		cmp = func(a, b row) int { return compareUint(a.Resets, b.Resets) }
+	case "bytes":
+		cmp = func(a, b row) int { return compareUint(a.Size, b.Size) }
+	case "starts":
+		cmp = func(a, b row) int { return compareTime(a.StartTime, b.StartTime) }
+	case "ends":
+		cmp = func(a, b row) int { return compareTime(a.EndTime, b.EndTime) }
+	default:
+		return fmt.Errorf("unknown sort field %s", field)
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		c := cmp(rows[i], rows[j])
+		if desc {
+			c = -c
+		}
+		if c == 0 {
+			return rows[i].key.Less(rows[j].key)
+		}
+		return c < 0
+	})
+	return nil
+}
+
+func sortGaps(gaps []gap, spec string) error {
+	var (
+		field string
+		desc  bool
+		err   error
+	)
+	if spec != "" {
+		if field, desc, err = parseOrder(spec); err != nil {
+			return err
+		}
+	}
+	var cmp func(a, b gap) int
+	switch field {
+	case "":
+		cmp = func(a, b gap) int { return 0 }
+	case Channel, Origin, UPI:
+		cmp = func(a, b gap) int { return compareKey(a.key, b.key, field) }
+	case "time", "starts":
+		cmp = func(a, b gap) int { return compareTime(a.Starts, b.Starts) }
+	case "ends":
+		cmp = func(a, b gap) int { return compareTime(a.Ends, b.Ends) }
+	case "missing":
+		cmp = func(a, b gap) int { return compareUint(uint64(a.Missing()), uint64(b.Missing())) }
+	case "duration":
+		cmp = func(a, b gap) int { return compareUint(uint64(a.Duration()), uint64(b.Duration())) }
+	default:
+		return fmt.Errorf("unknown sort field %s", field)
+	}
+	sort.SliceStable(gaps, func(i, j int) bool {
+		c := cmp(gaps[i], gaps[j])
+		if desc {
+			c = -c
+		}
+		if c == 0 {
+			if !gaps[i].key.Same(gaps[j].key) {
+				return gaps[i].key.Less(gaps[j].key)
+			}
+			return gaps[i].Starts.Before(gaps[j].Starts)
+		}
+		return c < 0
+	})
+	return nil
+}
+
+func compareKey(a, b key, field string) int {
+	switch field {
+	case Channel:
+		return compareUint(uint64(a.Channel), uint64(b.Channel))
+	case Origin:
+		return compareUint(uint64(a.Origin), uint64(b.Origin))
+	case UPI:
+		return strings.Compare(a.UPI, b.UPI)
+	default:
+		return compareTime(a.Time, b.Time)
+	}
+}
+
+func compareUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareTime(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/busoc/vmu"
+)
+
+func testRows() []row {
+	var (
+		start = time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+		stats = make(map[key]coze)
+	)
+	for i, n := range []uint64{1, 4, 2} {
+		k := key{Channel: vmu.VIC1, Time: start.Add(time.Duration(i) * time.Minute)}
+		cz := coze{}
+		cz.Count, cz.Types[vmu.PNG] = n, n
+		stats[k] = cz
+	}
+	for i, n := range []uint64{8, 8} {
+		k := key{Channel: vmu.LRSD, Time: start.Add(time.Duration(i+2) * time.Minute)}
+		cz := coze{}
+		cz.Count, cz.Types[0] = n, n
+		stats[k] = cz
+	}
+	return fillRows(stats, time.Minute)
+}
+
+func TestRowsTotalBreakdown(t *testing.T) {
+	var buf bytes.Buffer
+	o := output{CSV: true, Total: true}
+	if err := o.Rows(&buf, groupChannel, testRows(), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if total := lines[len(lines)-1]; !strings.Contains(total, "dat:16") || !strings.Contains(total, "png:7") {
+		t.Errorf("footer without breakdown by type: %s", total)
+	}
+}
+
+func TestSparklinesSortAndTotal(t *testing.T) {
+	var buf bytes.Buffer
+	o := output{Spark: true, Total: true, Sort: "count:desc"}
+	if err := o.Rows(&buf, groupChannel, testRows(), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"lrsd                     ▁▁ min=8 max=8 total=16",
+		"vic1                     ▁█▃ min=1 max=4 total=7",
+		"total                    ▁▃█▆ min=1 max=10 total=23",
+	}
+	if got := strings.Split(strings.TrimSpace(buf.String()), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	o.Sort = "unknown"
+	if err := o.Rows(&buf, groupChannel, testRows(), time.Minute); err == nil {
+		t.Errorf("unknown sort field accepted")
+	}
+}
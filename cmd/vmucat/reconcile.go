@@ -0,0 +1,406 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/busoc/rt"
+	"github.com/busoc/vmu"
+	"github.com/midbel/cli"
+	"github.com/midbel/linewriter"
+)
+
+// streamKey identifies the packets of a stream, whose counters follow each
+// other.
+type streamKey struct {
+	Origin uint8
+	UPI    string
+}
+
+func keyOf(p vmu.Packet) streamKey {
+	return streamKey{Origin: p.DataHeader.Origin, UPI: string(p.DataHeader.UserInfo())}
+}
+
+// stream holds the last realtime packet of an origin and UPI and the first
+// copy of each playback packet received since then. Before the first realtime
+// packet, Seen is false and the playback packets received are those lost
+// before the start of the realtime stream.
+type stream struct {
+	Seen     bool
+	Last     vmu.Packet
+	Playback map[uint32]vmu.Packet
+	gaps     vmu.GapTracker
+}
+
+func runReconcile(cmd *cli.Command, args []string) error {
+	csv := cmd.Flag.Bool("c", false, "csv format")
+	keepInvalid := cmd.Flag.Bool("e", false, "keep invalid packets")
+	file := cmd.Flag.String("w", "", "write merged archive")
+	window := cmd.Flag.Duration("window", time.Second, "reorder packets whose acquisition times differ by less than window")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	rc := reconciler{
+		line:    Line(*csv),
+		report:  os.Stdout,
+		streams: make(map[streamKey]*stream),
+	}
+	if *file != "" {
+		f, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		rc.archive = f
+	}
+
+	// realtime and playback packets are read by two cursors over the same
+	// archives, each one giving the packets of its stream by acquisition time.
+	var cs [2]*cursor
+	for i := range cs {
+		mr, err := browseArchives(cmd.Flag.Args())
+		if err != nil {
+			return err
+		}
+		defer mr.Close()
+		d := vmu.NewDecoder(rt.NewReader(mr), nil)
+		defer d.Close()
+
+		cs[i] = &cursor{
+			dec:      d,
+			realtime: i == 0,
+			data:     *file != "",
+			valid:    !*keepInvalid,
+			window:   *window,
+		}
+	}
+	realtime, playback := cs[0], cs[1]
+	for {
+		r, rok, err := realtime.Peek()
+		if err != nil {
+			return err
+		}
+		p, pok, err := playback.Peek()
+		if err != nil {
+			return err
+		}
+		if !rok && !pok {
+			return rc.Flush()
+		}
+		if !rok || (pok && p.DataHeader.Acquisition().Before(r.DataHeader.Acquisition())) {
+			playback.Pop()
+			rc.Playback(p)
+			continue
+		}
+		realtime.Pop()
+		if err := rc.Realtime(r); err != nil {
+			return err
+		}
+	}
+}
+
+// reconciler fills the gaps of the realtime streams with playback packets. It
+// reports every gap with the counters that playback did not recover, and
+// writes the realtime packets and the playback packets filling their gaps to
+// archive, if any.
+type reconciler struct {
+	line    *linewriter.Writer
+	report  io.Writer
+	archive io.Writer
+	streams map[streamKey]*stream
+}
+
+func (rc *reconciler) stream(k streamKey) *stream {
+	s, ok := rc.streams[k]
+	if !ok {
+		s = &stream{Playback: make(map[uint32]vmu.Packet)}
+		rc.streams[k] = s
+	}
+	return s
+}
+
+// Playback records p until the realtime packet following it, keeping the
+// first copy of each counter.
+func (rc *reconciler) Playback(p vmu.Packet) {
+	s := rc.stream(keyOf(p))
+	c := p.DataHeader.Counter
+	if s.Seen && c == s.Last.DataHeader.Counter {
+		return
+	}
+	if _, ok := s.Playback[c]; !ok {
+		s.Playback[c] = p
+	}
+}
+
+// Realtime fills the gap between the last realtime packet of the stream of r
+// and r, then writes r.
+func (rc *reconciler) Realtime(r vmu.Packet) error {
+	k := keyOf(r)
+	s := rc.stream(k)
+	if !s.Seen {
+		// playback packets acquired before the first realtime packet are the
+		// counters lost before it.
+		if n := s.before(r); n > 0 {
+			first := r.DataHeader.Counter - n - 1
+			filled := s.Fill(first, n, time.Time{}, r.DataHeader.Acquisition())
+			if err := rc.gap(k, filled[0].DataHeader.Acquisition(), r.DataHeader.Acquisition(), first, r.DataHeader.Counter, filled); err != nil {
+				return err
+			}
+		}
+	}
+	if g, ok := s.gaps.Next(r.DataHeader.Counter, r.DataHeader.Acquisition()); ok && (g.Kind == vmu.GapLoss || g.Kind == vmu.GapWrap) {
+		filled := s.Fill(s.Last.DataHeader.Counter, g.Missing(), s.Last.DataHeader.Acquisition(), r.DataHeader.Acquisition())
+		if err := rc.gap(k, g.Starts, g.Ends, g.Last, g.First, filled); err != nil {
+			return err
+		}
+	}
+	if err := writePackets(rc.archive, []vmu.Packet{r}); err != nil {
+		return err
+	}
+	s.Seen, s.Last = true, r
+	if len(s.Playback) > 0 {
+		s.Playback = make(map[uint32]vmu.Packet)
+	}
+	return nil
+}
+
+// Flush writes the playback packets received after the last realtime packet
+// of their stream, or of streams without realtime packets.
+func (rc *reconciler) Flush() error {
+	ks := make([]streamKey, 0, len(rc.streams))
+	for k := range rc.streams {
+		ks = append(ks, k)
+	}
+	sort.Slice(ks, func(i, j int) bool {
+		if ks[i].Origin != ks[j].Origin {
+			return ks[i].Origin < ks[j].Origin
+		}
+		return ks[i].UPI < ks[j].UPI
+	})
+	for _, k := range ks {
+		s := rc.streams[k]
+		if len(s.Playback) == 0 {
+			continue
+		}
+		var (
+			first  uint32
+			starts time.Time
+			n      uint32
+		)
+		if s.Seen {
+			first, starts = s.Last.DataHeader.Counter, s.Last.DataHeader.Acquisition()
+			for c := range s.Playback {
+				if d := c - first; d <= vmu.WrapWindow && d > n {
+					n = d
+				}
+			}
+		} else {
+			// the counters of the playback packets are the only ones known.
+			cs := make([]uint32, 0, len(s.Playback))
+			for c := range s.Playback {
+				cs = append(cs, c)
+			}
+			sort.Slice(cs, func(i, j int) bool { return cs[i] < cs[j] })
+			first, n = cs[0]-1, cs[len(cs)-1]-cs[0]+1
+		}
+		filled := s.Fill(first, n, starts, time.Time{})
+		if len(filled) == 0 {
+			continue
+		}
+		last := filled[len(filled)-1]
+		if err := rc.gap(k, starts, last.DataHeader.Acquisition(), first, last.DataHeader.Counter+1, filled); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gap reports the gap of the stream k between the counters last and first
+// and writes the playback packets filling it.
+func (rc *reconciler) gap(k streamKey, starts, ends time.Time, last, first uint32, filled []vmu.Packet) error {
+	var (
+		missing = first - last - 1
+		lost    = lostCounters(last, first, filled)
+	)
+	rc.line.AppendUint(uint64(k.Origin), 2, linewriter.AlignCenter|linewriter.Hex|linewriter.WithZero)
+	rc.line.AppendString(k.UPI, 16, linewriter.AlignLeft|linewriter.Text)
+	rc.line.AppendTime(starts, rt.TimeFormat, linewriter.AlignRight)
+	rc.line.AppendTime(ends, rt.TimeFormat, linewriter.AlignRight)
+	rc.line.AppendUint(uint64(last), 8, linewriter.AlignRight)
+	rc.line.AppendUint(uint64(first), 8, linewriter.AlignRight)
+	rc.line.AppendUint(uint64(missing), 8, linewriter.AlignRight)
+	rc.line.AppendUint(uint64(len(filled)), 8, linewriter.AlignRight)
+	rc.line.AppendUint(uint64(missing)-uint64(len(filled)), 8, linewriter.AlignRight)
+	rc.line.AppendString(formatCounters(lost), 0, linewriter.AlignLeft|linewriter.Text)
+	if _, err := io.Copy(rc.report, rc.line); err != nil {
+		return err
+	}
+	return writePackets(rc.archive, filled)
+}
+
+// before returns the number of counters between r and the oldest playback
+// packet of s acquired before r, or zero without such packets.
+func (s stream) before(r vmu.Packet) uint32 {
+	var n uint32
+	for c, p := range s.Playback {
+		if p.DataHeader.Acquisition().After(r.DataHeader.Acquisition()) {
+			continue
+		}
+		if d := r.DataHeader.Counter - c; d <= vmu.WrapWindow && d > n {
+			n = d
+		}
+	}
+	return n
+}
+
+// Fill returns, ordered by counter, the playback packets whose counters are
+// the missing ones following last and whose acquisition times fall between
+// starts and ends. A zero time sets no bound.
+func (s stream) Fill(last, missing uint32, starts, ends time.Time) []vmu.Packet {
+	var ps []vmu.Packet
+	for c, p := range s.Playback {
+		if c-last-1 >= missing {
+			continue
+		}
+		w := p.DataHeader.Acquisition()
+		if (!starts.IsZero() && w.Before(starts)) || (!ends.IsZero() && w.After(ends)) {
+			continue
+		}
+		ps = append(ps, p)
+	}
+	sort.Slice(ps, func(i, j int) bool {
+		return ps[i].DataHeader.Counter-last < ps[j].DataHeader.Counter-last
+	})
+	return ps
+}
+
+// lostCounters returns the counters between last and first that are not in
+// filled, ordered by counter. filled is ordered by counter.
+func lostCounters(last, first uint32, filled []vmu.Packet) []uint32 {
+	var cs []uint32
+	for c := last + 1; c != first; c++ {
+		if len(filled) > 0 && filled[0].DataHeader.Counter == c {
+			filled = filled[1:]
+			continue
+		}
+		cs = append(cs, c)
+	}
+	return cs
+}
+
+// formatCounters writes cs as a comma separated list of ranges of consecutive
+// counters (eg: 4-7,9).
+func formatCounters(cs []uint32) string {
+	var str []string
+	for i := 0; i < len(cs); {
+		j := i + 1
+		for j < len(cs) && cs[j] == cs[j-1]+1 {
+			j++
+		}
+		if j-i == 1 {
+			str = append(str, strconv.FormatUint(uint64(cs[i]), 10))
+		} else {
+			str = append(str, fmt.Sprintf("%d-%d", cs[i], cs[j-1]))
+		}
+		i = j
+	}
+	return strings.Join(str, ",")
+}
+
+// cursor gives the realtime or the playback packets of a decoder ordered by
+// acquisition time. Packets are kept in a queue until the acquisition time of
+// the last packet read is window after the first one, so only packets less
+// than window out of order are reordered.
+type cursor struct {
+	dec      *vmu.Decoder
+	realtime bool
+	data     bool
+	valid    bool
+	window   time.Duration
+
+	queue []vmu.Packet
+	done  bool
+}
+
+// Peek returns the next packet of c without removing it. It returns false
+// once every packet has been read.
+func (c *cursor) Peek() (vmu.Packet, bool, error) {
+	for !c.done && (len(c.queue) == 0 || c.span() < c.window) {
+		if err := c.read(); err != nil {
+			return vmu.Packet{}, false, err
+		}
+	}
+	if len(c.queue) == 0 {
+		return vmu.Packet{}, false, nil
+	}
+	return c.queue[0], true, nil
+}
+
+func (c *cursor) Pop() {
+	if len(c.queue) > 0 {
+		c.queue = c.queue[1:]
+	}
+}
+
+func (c *cursor) span() time.Duration {
+	first, last := c.queue[0], c.queue[len(c.queue)-1]
+	return last.DataHeader.Acquisition().Sub(first.DataHeader.Acquisition())
+}
+
+func (c *cursor) read() error {
+	for {
+		p, err := c.dec.Decode(c.data)
+		switch err {
+		case nil, vmu.ErrInvalid:
+			if err == vmu.ErrInvalid && c.valid {
+				continue
+			}
+			if p.IsRealtime() != c.realtime {
+				continue
+			}
+			c.push(p)
+			return nil
+		case vmu.ErrSkip:
+		case io.EOF:
+			c.done = true
+			return nil
+		default:
+			return err
+		}
+	}
+}
+
+// push inserts p in the queue after the packets acquired before or at the
+// same time.
+func (c *cursor) push(p vmu.Packet) {
+	w := p.DataHeader.Acquisition()
+	ix := len(c.queue)
+	for ix > 0 && w.Before(c.queue[ix-1].DataHeader.Acquisition()) {
+		ix--
+	}
+	c.queue = append(c.queue, vmu.Packet{})
+	copy(c.queue[ix+1:], c.queue[ix:])
+	c.queue[ix] = p
+}
+
+// writePackets writes ps to w, if any, in the layout of rt archives.
+func writePackets(w io.Writer, ps []vmu.Packet) error {
+	if w == nil {
+		return nil
+	}
+	for _, p := range ps {
+		buf, err := p.MarshalHRDP()
+		if err != nil {
+			continue
+		}
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
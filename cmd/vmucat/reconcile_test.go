@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/busoc/vmu"
+)
+
+func science(upi string, counter uint32, acq time.Duration, playback bool) vmu.Packet {
+	var p vmu.Packet
+	p.VMUHeader = vmu.VMUHeader{Channel: vmu.LRSD, Origin: 0x33, Sequence: counter}
+	if playback {
+		p.VMUHeader.Origin |= 0x80
+	}
+	p.DataHeader = vmu.DataHeader{Property: 0x10, Origin: 0x33, Counter: counter, AcqTime: acq}
+	copy(p.DataHeader.UPI[:], upi)
+	p.Data = []byte{byte(counter)}
+	return p
+}
+
+func TestReconcile(t *testing.T) {
+	const s = time.Second
+	var (
+		report  bytes.Buffer
+		archive bytes.Buffer
+		rc      = reconciler{
+			line:    Line(true),
+			report:  &report,
+			archive: &archive,
+			streams: make(map[streamKey]*stream),
+		}
+	)
+	// counters 1 and 2 of A are only received as playback before the first
+	// realtime packet, 5 and 7 fill the gap between 4 and 8 but 6 is lost. The
+	// counters of B interleaved with A are not gaps of A.
+	rc.Playback(science("A", 1, 1*s, true))
+	rc.Playback(science("A", 2, 2*s, true))
+	for _, p := range []vmu.Packet{
+		science("A", 3, 3*s, false),
+		science("B", 100, 3*s, false),
+		science("A", 4, 4*s, false),
+		science("B", 101, 4*s, false),
+	} {
+		if err := rc.Realtime(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rc.Playback(science("A", 5, 5*s, true))
+	rc.Playback(science("A", 7, 7*s, true))
+	rc.Playback(science("A", 5, 5*s, true))
+	if err := rc.Realtime(science("A", 8, 8*s, false)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(report.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("%d gaps reported, want 2:\n%s", len(lines), report.String())
+	}
+	for i, want := range []string{"", "6"} {
+		fs := strings.Split(lines[i], ",")
+		if got := strings.Trim(fs[len(fs)-1], `"`); got != want {
+			t.Errorf("gap %d: missing counters %q, want %q", i+1, got, want)
+		}
+	}
+
+	var got []uint32
+	for buf := archive.Bytes(); len(buf) > 0; {
+		size := 4 + int(binary.LittleEndian.Uint32(buf))
+		p, err := vmu.DecodePacket(buf[:size], false)
+		if err != nil && err != vmu.ErrInvalid {
+			t.Fatal(err)
+		}
+		if p.HRDPHeader.Size != uint32(size-4) {
+			t.Fatalf("packet %d written without its HRDP header", p.DataHeader.Counter)
+		}
+		got = append(got, p.DataHeader.Counter)
+		buf = buf[size:]
+	}
+	want := []uint32{1, 2, 3, 100, 4, 101, 5, 7, 8}
+	if len(got) != len(want) {
+		t.Fatalf("counters written: got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("counters written: got %v, want %v", got, want)
+		}
+	}
+}
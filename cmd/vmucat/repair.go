@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/busoc/vmu"
+	"github.com/midbel/cli"
+)
+
+func runRepair(cmd *cli.Command, args []string) error {
+	report := cmd.Flag.String("r", "", "write discarded byte ranges to report")
+	framing := cmd.Flag.String("f", "", "framing of final file (hrdp, hrdl)")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	if cmd.Flag.NArg() != 2 {
+		return fmt.Errorf("final and damaged files expected")
+	}
+	file := cmd.Flag.Arg(1)
+
+	target := strings.ToLower(*framing)
+	switch target {
+	case "":
+		f, err := detectFraming(file)
+		if err != nil {
+			return err
+		}
+		target = f
+	case framingHRDP, framingHRDL:
+	default:
+		return fmt.Errorf("unknown framing %s", *framing)
+	}
+	r, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	f, err := os.Create(cmd.Flag.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var w io.Writer = os.Stderr
+	if *report != "" {
+		rf, err := os.Create(*report)
+		if err != nil {
+			return err
+		}
+		defer rf.Close()
+		w = rf
+	}
+
+	count, ds, err := vmu.Repair(f, r, target == framingHRDP)
+	if err != nil {
+		return err
+	}
+	var discarded int
+	for _, d := range ds {
+		fmt.Fprintf(w, "discard %d-%d (%d bytes): %s\n", d.Offset, d.Offset+d.Length, d.Length, d.Reason)
+		discarded += d.Length
+	}
+	log.Printf("%d packets recovered, %d bytes discarded in %d ranges", count, discarded, len(ds))
+	return nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/busoc/rt"
+	"github.com/busoc/vmu"
+	"github.com/midbel/cli"
+)
+
+type replayer struct {
+	Speed   float64
+	Clock   string
+	Channel int
+	Origin  int
+	Invalid bool
+
+	Drop    float64
+	Corrupt float64
+	Reorder float64
+
+	rand *rand.Rand
+
+	state struct {
+		Count   int
+		Dropped int
+		Size    int
+
+		Time time.Time
+		Wall time.Time
+	}
+}
+
+func runReplay(cmd *cli.Command, args []string) error {
+	var r replayer
+	cmd.Flag.Float64Var(&r.Speed, "x", 1, "speed multiplier (0: no pacing)")
+	cmd.Flag.StringVar(&r.Clock, "t", "vmu", "pace packets with vmu or hrdp time")
+	cmd.Flag.IntVar(&r.Channel, "i", 0, "channel")
+	cmd.Flag.IntVar(&r.Origin, "o", 0, "origin")
+	cmd.Flag.BoolVar(&r.Invalid, "e", false, "keep invalid packets")
+	cmd.Flag.Float64Var(&r.Drop, "drop", 0, "probability to drop a packet")
+	cmd.Flag.Float64Var(&r.Corrupt, "corrupt", 0, "probability to corrupt the checksum of a packet")
+	cmd.Flag.Float64Var(&r.Reorder, "reorder", 0, "probability to swap a packet with the next one")
+	proto := cmd.Flag.String("p", "udp", "protocol (udp, tcp)")
+	loop := cmd.Flag.Bool("l", false, "loop over the archive")
+	seed := cmd.Flag.Int64("seed", 0, "seed of fault injection")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	r.rand = rand.New(rand.NewSource(*seed))
+
+	c, err := net.Dial(strings.ToLower(*proto), cmd.Flag.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	for {
+		if err := r.Replay(c, cmd.Flag.Args()[1:]); err != nil {
+			return err
+		}
+		if !*loop {
+			break
+		}
+		r.state.Time = time.Time{}
+	}
+	log.Printf("%d packets sent (%d dropped, %dKB)", r.state.Count, r.state.Dropped, r.state.Size>>10)
+	return nil
+}
+
+// Replay sends the packets of the archives found in dirs to w as bare HRDL
+// frames.
+func (r *replayer) Replay(w io.Writer, dirs []string) error {
+	mr, err := browseArchives(dirs)
+	if err != nil {
+		return err
+	}
+	defer mr.Close()
+
+	buf := vmu.AcquireBuffer()
+	defer vmu.ReleaseBuffer(buf)
+
+	var (
+		rs      = rt.NewReader(mr)
+		buffer  = *buf
+		delayed []byte
+	)
+	for {
+		n, err := rs.Read(buffer)
+		switch err {
+		case nil:
+		case io.EOF:
+			if delayed != nil {
+				return r.send(w, delayed)
+			}
+			return nil
+		default:
+			return err
+		}
+		p, err := vmu.DecodePacket(buffer[:n], false)
+		if err != nil && (err != vmu.ErrInvalid || !r.Invalid) {
+			continue
+		}
+		if !r.Keep(p) {
+			continue
+		}
+		r.wait(p)
+
+		frame := buffer[:n]
+		if binary.BigEndian.Uint32(frame) != vmu.Syncword {
+			frame = frame[vmu.HRDPHeaderLen:]
+		}
+		if r.Drop > 0 && r.rand.Float64() < r.Drop {
+			r.state.Dropped++
+			continue
+		}
+		frame = append([]byte(nil), frame...)
+		if r.Corrupt > 0 && r.rand.Float64() < r.Corrupt {
+			frame[len(frame)-1] ^= 0xFF
+		}
+		if delayed == nil && r.Reorder > 0 && r.rand.Float64() < r.Reorder {
+			delayed = frame
+			continue
+		}
+		if err := r.send(w, frame); err != nil {
+			return err
+		}
+		if delayed != nil {
+			if err := r.send(w, delayed); err != nil {
+				return err
+			}
+			delayed = nil
+		}
+	}
+}
+
+func (r *replayer) Keep(p vmu.Packet) bool {
+	if r.Channel > 0 && uint8(r.Channel) != p.VMUHeader.Channel {
+		return false
+	}
+	if r.Origin > 0 && uint8(r.Origin) != p.DataHeader.Origin {
+		return false
+	}
+	return true
+}
+
+func (r *replayer) send(w io.Writer, frame []byte) error {
+	n, err := w.Write(frame)
+	if err == nil {
+		r.state.Count++
+		r.state.Size += n
+	}
+	return err
+}
+
+// wait sleeps until the packet should be sent according to its original
+// time, divided by the speed multiplier.
+func (r *replayer) wait(p vmu.Packet) {
+	if r.Speed <= 0 {
+		return
+	}
+	when := p.VMUHeader.Timestamp()
+	if r.Clock == "hrdp" && p.HRDPHeader.Size > 0 {
+		when = p.HRDPHeader.Archive()
+	}
+	if r.state.Time.IsZero() {
+		r.state.Time, r.state.Wall = when, time.Now()
+		return
+	}
+	elapsed := time.Duration(float64(when.Sub(r.state.Time)) / r.Speed)
+	if d := time.Until(r.state.Wall.Add(elapsed)); d > 0 {
+		time.Sleep(d)
+	}
+}
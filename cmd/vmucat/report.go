@@ -11,26 +11,44 @@ import (
 	"github.com/busoc/rt"
 	"github.com/busoc/vmu"
 	"github.com/midbel/cli"
-	"github.com/midbel/linewriter"
 )
 
 const (
 	Channel = "channel"
 	Origin  = "origin"
+	UPI     = "upi"
+	Stream  = "stream"
 )
 
 func runList(cmd *cli.Command, args []string) error {
 	csv := cmd.Flag.Bool("c", false, "csv format")
 	keepInvalid := cmd.Flag.Bool("e", false, "keep invalid packets")
+	follow := cmd.Flag.Bool("follow", false, "wait for new packets")
+	every := cmd.Flag.Duration("every", time.Second, "polling interval in follow mode")
+	metrics := cmd.Flag.String("metrics", "", "expose metrics on address")
+	var q query
+	q.Register(&cmd.Flag)
+	cmd.Flag.IntVar(&q.Channel, "i", 0, "channel")
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
-	mr, err := rt.Browse(cmd.Flag.Args(), true)
+	var (
+		mr  io.ReadCloser
+		err error
+	)
+	if *follow {
+		mr, err = browse(cmd.Flag.Args(), true, *every)
+	} else {
+		mr, err = openArchives(cmd.Flag.Args(), q.Query())
+	}
 	if err != nil {
 		return err
 	}
+	defer mr.Close()
 	rt := rt.NewReader(mr)
+	filter := q.Query()
 	d := vmu.Dump(os.Stdout, *csv)
+	d.SetMetrics(serveMetrics(*metrics))
 
 	c := struct {
 		Invalid int
@@ -38,10 +56,19 @@ func runList(cmd *cli.Command, args []string) error {
 		Skipped int
 		Count   int
 	}{}
-	buffer := make([]byte, vmu.BufferSize)
+	buf := vmu.AcquireBuffer()
+	defer vmu.ReleaseBuffer(buf)
+
+	buffer := *buf
 	for i := 0; ; i++ {
 		switch n, err := rt.Read(buffer); err {
 		case nil:
+			if !filter.IsZero() {
+				p, err := vmu.DecodePacket(buffer[:n], false)
+				if (err == nil || err == vmu.ErrInvalid) && !filter.Keep(p) {
+					continue
+				}
+			}
 			c.Size += n
 			if err := d.Dump(buffer[:n], *keepInvalid, false); err != nil {
 				if err == vmu.ErrInvalid {
@@ -63,209 +90,341 @@ func runList(cmd *cli.Command, args []string) error {
 }
 
 func runCount(cmd *cli.Command, args []string) error {
-	csv := cmd.Flag.Bool("c", false, "csv format")
+	var o output
+	cmd.Flag.BoolVar(&o.CSV, "c", false, "csv format")
+	cmd.Flag.BoolVar(&o.JSON, "j", false, "json format")
+	cmd.Flag.BoolVar(&o.Spark, "s", false, "print count as sparklines")
+	cmd.Flag.BoolVar(&o.Total, "total", false, "print total of all groups")
+	cmd.Flag.StringVar(&o.Sort, "sort", "", "sort rows by field[:asc|desc]")
 	keepInvalid := cmd.Flag.Bool("e", false, "keep invalid packets")
-	by := cmd.Flag.String("b", "", "count packets by channel or origin")
+	by := cmd.Flag.String("b", "", "count packets by channel, origin and/or upi")
 	interval := cmd.Flag.Duration("i", 0, "interval")
+	summary := cmd.Flag.String("w", "", "write a markdown or html summary table")
+	follow := cmd.Flag.Bool("follow", false, "wait for new packets until interrupted")
+	every := cmd.Flag.Duration("every", time.Second, "polling interval in follow mode")
+	metrics := cmd.Flag.String("metrics", "", "expose metrics on address")
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
+	g, err := parseGroup(*by)
+	if err != nil {
+		return err
+	}
 
-	mr, err := rt.Browse(cmd.Flag.Args(), true)
+	mr, err := browse(cmd.Flag.Args(), *follow, *every)
 	if err != nil {
 		return err
 	}
 	defer mr.Close()
 
-	stats, err := countPackets(rt.NewReader(mr), strings.ToLower(*by), !*keepInvalid, *interval)
+	d := vmu.NewDecoder(rt.NewReader(mr), nil)
+	defer d.Close()
+
+	stats, err := countPackets(d, g, !*keepInvalid, *interval, serveMetrics(*metrics))
 	if err != nil {
 		return err
 	}
-	line := Line(*csv)
-	for k, cz := range stats {
-		line.AppendBytes(vmu.WhichChannel(k.Channel), 4, linewriter.Text|linewriter.AlignLeft)
-		if strings.ToLower(*by) == Origin {
-			line.AppendUint(uint64(k.Origin), 2, linewriter.AlignCenter|linewriter.Hex|linewriter.WithZero)
-		}
-		line.AppendUint(cz.Count, 6, linewriter.AlignRight)
-		line.AppendUint(cz.Missing, 6, linewriter.AlignRight)
-		line.AppendUint(cz.Error, 6, linewriter.AlignRight)
-		if *csv {
-			line.AppendUint(cz.Size, 8, linewriter.AlignRight)
-		} else {
-			line.AppendSize(int64(cz.Size), 8, linewriter.AlignRight)
+	rows := fillRows(stats, *interval)
+	if *summary != "" {
+		if err := writeSummary(*summary, g, rows); err != nil {
+			return err
 		}
-		line.AppendUint(cz.First, 8, linewriter.AlignRight)
-		line.AppendTime(cz.StartTime, rt.TimeFormat, linewriter.AlignRight)
-		line.AppendUint(cz.Last, 8, linewriter.AlignRight)
-		line.AppendTime(cz.EndTime, rt.TimeFormat, linewriter.AlignRight)
-		io.Copy(os.Stdout, line)
 	}
-	return nil
+	return o.Rows(os.Stdout, g, rows, *interval)
 }
 
 func runDiff(cmd *cli.Command, args []string) error {
-	csv := cmd.Flag.Bool("c", false, "csv format")
+	var o output
+	cmd.Flag.BoolVar(&o.CSV, "c", false, "csv format")
+	cmd.Flag.BoolVar(&o.JSON, "j", false, "json format")
+	cmd.Flag.BoolVar(&o.Spark, "s", false, "print histogram as sparklines")
+	cmd.Flag.BoolVar(&o.Total, "total", false, "print total of all gaps")
+	cmd.Flag.StringVar(&o.Sort, "sort", "", "sort rows by field[:asc|desc]")
 	keepInvalid := cmd.Flag.Bool("e", false, "keep invalid packets")
-	by := cmd.Flag.String("b", "", "count packets by channel or origin")
+	by := cmd.Flag.String("b", "", "count packets by channel, origin and/or upi")
 	duration := cmd.Flag.Duration("d", time.Second, "maximum gap duration")
+	interval := cmd.Flag.Duration("i", 0, "histogram of gaps by interval")
+	follow := cmd.Flag.Bool("follow", false, "wait for new packets and print gaps as they are found")
+	every := cmd.Flag.Duration("every", time.Second, "polling interval in follow mode")
+	metrics := cmd.Flag.String("metrics", "", "expose metrics on address")
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
-
-	var (
-		getBy func(vmu.Packet, time.Duration) key
-		gapBy func(vmu.Packet, vmu.Packet, time.Duration) (bool, rt.Gap)
-	)
-
-	switch strings.ToLower(*by) {
-	case Channel, "":
-		getBy = byChannel
-		gapBy = gapByChannel
-	case Origin:
-		getBy = byOrigin
-		gapBy = gapByOrigin
-	default:
-		return fmt.Errorf("unknown value %s", *by)
+	if o.Spark && *interval <= 0 {
+		return fmt.Errorf("sparklines need an interval (-i)")
+	}
+	grp, err := parseGroup(*by)
+	if err != nil {
+		return err
 	}
 
-	mr, err := rt.Browse(cmd.Flag.Args(), true)
+	mr, err := browse(cmd.Flag.Args(), *follow, *every)
 	if err != nil {
 		return err
 	}
 	defer mr.Close()
 
+	var (
+		stats = make(map[key]coze)
+		gaps  []gap
+	)
 	d := vmu.NewDecoder(rt.NewReader(mr), nil)
+	defer d.Close()
 
-	seen := make(map[key]vmu.Packet)
-	line := Line(*csv)
+	err = findGaps(d, grp, !*keepInvalid, *duration, serveMetrics(*metrics), func(g gap) error {
+		switch {
+		case *interval > 0:
+			k := g.key
+			k.Time = g.Starts.Truncate(*interval)
+			stats[k] = addGap(stats[k], g.Gap)
+		case *follow:
+			live := o
+			live.Total = false
+			return live.Gaps(os.Stdout, grp, []gap{g})
+		default:
+			gaps = append(gaps, g)
+		}
+		return nil
+	})
+	switch {
+	case err != nil:
+		return err
+	case *interval > 0:
+		return o.Rows(os.Stdout, grp, fillRows(stats, *interval), *interval)
+	case *follow:
+		return nil
+	default:
+		return o.Gaps(os.Stdout, grp, gaps)
+	}
+}
+
+// decoder gives the packets of a set of archives, read from the archives
+// themselves or from their indexes.
+type decoder interface {
+	DecodeView(data bool) (vmu.Packet, error)
+}
+
+// trusted reports whether the headers of the packet last decoded by d, with
+// err, can be trusted to attribute it to a channel, origin and UPI. Only
+// invalid packets read from the archives themselves are diagnosed.
+func trusted(d decoder, err error) bool {
+	if err != vmu.ErrInvalid {
+		return true
+	}
+	x, ok := d.(interface{ Diagnose() vmu.Diagnosis })
+	return !ok || x.Diagnose().Attributed
+}
+
+// findGaps calls fn for every gap, no longer than duration, found between two
+// consecutive packets of the same group. Every packet read is recorded in m.
+// Invalid packets that can not be attributed to a group are ignored.
+func findGaps(d decoder, grp group, valid bool, duration time.Duration, m *vmu.Metrics, fn func(gap) error) error {
+	seen := make(map[key]*vmu.GapTracker)
 	for {
-		switch p, err := d.Decode(false); err {
+		switch p, err := d.DecodeView(false); err {
 		case nil, vmu.ErrInvalid:
-			if err == vmu.ErrInvalid && !*keepInvalid {
+			m.Update(p, err)
+			if err == vmu.ErrInvalid && (valid || !trusted(d, err)) {
 				continue
 			}
-			k := getBy(p, 0)
-			if prev, ok := seen[k]; ok {
-				if ok, g := gapBy(p, prev, *duration); ok {
-					line.AppendBytes(vmu.WhichChannel(p.VMUHeader.Channel), 4, linewriter.Text|linewriter.AlignLeft)
-					if strings.ToLower(*by) == "origin" {
-						line.AppendUint(uint64(p.DataHeader.Origin), 2, linewriter.AlignCenter|linewriter.Hex|linewriter.WithZero)
-					}
-					line.AppendTime(g.Starts, rt.TimeFormat, linewriter.AlignRight)
-					line.AppendTime(g.Ends, rt.TimeFormat, linewriter.AlignRight)
-					line.AppendInt(int64(g.Last), 8, linewriter.AlignRight)
-					line.AppendInt(int64(g.First), 8, linewriter.AlignRight)
-					line.AppendInt(int64(g.Missing()), 8, linewriter.AlignRight)
-					line.AppendDuration(g.Duration(), 10, linewriter.AlignRight)
-
-					io.Copy(os.Stdout, line)
+			k := grp.Key(p, 0)
+			t, ok := seen[k]
+			if !ok {
+				t = new(vmu.GapTracker)
+				seen[k] = t
+			}
+			if g, ok := grp.Gap(t, p); ok && g.Kind != vmu.GapNone && (duration == 0 || g.Duration() <= duration) {
+				if err := fn(gap{key: k, Gap: g}); err != nil {
+					return err
 				}
 			}
-			seen[k] = p
 		case vmu.ErrSkip:
+			m.Skip()
 		case io.EOF:
 			return nil
 		default:
 			return err
 		}
 	}
-	return nil
+}
+
+// addGap accumulates g in cz: Count is the number of gaps and Missing the
+// number of packets missing in these gaps.
+func addGap(cz coze, g vmu.Gap) coze {
+	cz.Count++
+	cz.Missing += uint64(g.Missing())
+	if g.Kind == vmu.GapReset {
+		cz.Resets++
+	}
+	if cz.StartTime.IsZero() || g.Starts.Before(cz.StartTime) {
+		cz.First, cz.StartTime = uint64(g.Last), g.Starts
+	}
+	if g.Ends.After(cz.EndTime) {
+		cz.Last, cz.EndTime = uint64(g.First), g.Ends
+	}
+	return cz
 }
 
 type key struct {
 	Channel uint8
 	Origin  uint8
+	UPI     string
 	time.Time
 }
 
-func byChannel(p vmu.Packet, interval time.Duration) key {
-	k := key{Channel: p.VMUHeader.Channel}
-	if interval > 0 {
-		k.Time = p.VMUHeader.Timestamp().Truncate(interval)
+type group uint8
+
+const (
+	groupChannel group = 1 << iota
+	groupOrigin
+	groupUPI
+)
+
+// parseGroup accepts a comma separated list of channel, origin and upi. An
+// empty string groups packets by channel.
+func parseGroup(str string) (group, error) {
+	var g group
+	if str == "" {
+		return groupChannel, nil
 	}
-	return k
+	for _, f := range strings.Split(strings.ToLower(str), ",") {
+		switch strings.TrimSpace(f) {
+		case Channel:
+			g |= groupChannel
+		case Origin:
+			g |= groupOrigin
+		case UPI:
+			g |= groupUPI
+		default:
+			return g, fmt.Errorf("unknown value %s", f)
+		}
+	}
+	return g, nil
+}
+
+func (g group) Has(other group) bool {
+	return g&other == other
 }
 
-func byOrigin(p vmu.Packet, interval time.Duration) key {
-	k := byChannel(p, 0)
-	k.Origin = p.DataHeader.Origin
+// Key returns the key of p. Packets grouped by origin or upi are bucketed by
+// their acquisition time, the others by their VMU time.
+func (g group) Key(p vmu.Packet, interval time.Duration) key {
+	var k key
+	if g.Has(groupChannel) {
+		k.Channel = p.VMUHeader.Channel
+	}
+	if g.Has(groupOrigin) {
+		k.Origin = p.DataHeader.Origin
+	}
+	if g.Has(groupUPI) {
+		k.UPI = string(p.DataHeader.UserInfo())
+	}
 	if interval > 0 {
-		k.Time = p.DataHeader.Acquisition().Truncate(interval)
+		if g.Has(groupOrigin) || g.Has(groupUPI) {
+			k.Time = p.DataHeader.Acquisition().Truncate(interval)
+		} else {
+			k.Time = p.VMUHeader.Timestamp().Truncate(interval)
+		}
 	}
 	return k
 }
 
-func gapByChannel(p, prev vmu.Packet, duration time.Duration) (ok bool, g rt.Gap) {
-	last, first := int(p.VMUHeader.Sequence), int(prev.VMUHeader.Sequence)
-	delta := p.VMUHeader.Timestamp().Sub(prev.VMUHeader.Timestamp())
-
-	if diff := last - first; diff != 1 && (duration == 0 || delta <= duration) {
-		g.Last = first
-		g.First = last
-		g.Starts = prev.VMUHeader.Timestamp()
-		g.Ends = p.VMUHeader.Timestamp()
+// Unknown returns the key of the packets of the bucket of k that can not be
+// attributed to a group.
+func (g group) Unknown(k key) key {
+	u := key{Time: k.Time}
+	if g.Has(groupUPI) {
+		u.UPI = string(vmu.Unknown)
+	}
+	return u
+}
 
-		ok = true
+// Gap gives p to the tracker of its group and returns the gap found since the
+// previous packet of the group. The origin counter is used when packets are
+// grouped by origin or upi, the VMU sequence otherwise.
+func (g group) Gap(t *vmu.GapTracker, p vmu.Packet) (vmu.Gap, bool) {
+	if g.Has(groupOrigin) || g.Has(groupUPI) {
+		return t.Next(p.DataHeader.Counter, p.DataHeader.Acquisition())
 	}
-	return
+	return t.Next(p.VMUHeader.Sequence, p.VMUHeader.Timestamp())
 }
 
-func gapByOrigin(p, prev vmu.Packet, duration time.Duration) (ok bool, g rt.Gap) {
-	last, first := int(p.DataHeader.Counter), int(prev.DataHeader.Counter)
-	delta := p.DataHeader.Acquisition().Sub(prev.DataHeader.Acquisition())
+type coze struct {
+	rt.Coze
+	Resets uint64
+	Types  [vmu.H264 + 1]uint64
+}
 
-	if diff := last - first; diff != 1 && (duration == 0 || delta <= duration) {
-		g.Last = first
-		g.First = last
-		g.Starts = prev.DataHeader.Acquisition()
-		g.Ends = p.DataHeader.Acquisition()
+func (c *coze) Update(p vmu.Packet) {
+	if p.VMUHeader.Channel == vmu.LRSD || int(p.DataHeader.Type) >= len(c.Types) {
+		c.Types[0]++
+	} else {
+		c.Types[p.DataHeader.Type]++
+	}
+}
 
-		ok = true
+// Add adds the counts of other to cz and extends its time span to the one of
+// other. First and Last are left untouched.
+func (cz *coze) Add(other coze) {
+	cz.Count += other.Count
+	cz.Size += other.Size
+	cz.Missing += other.Missing
+	cz.Error += other.Error
+	cz.Resets += other.Resets
+	for i, n := range other.Types {
+		cz.Types[i] += n
+	}
+	if cz.StartTime.IsZero() || (!other.StartTime.IsZero() && other.StartTime.Before(cz.StartTime)) {
+		cz.StartTime = other.StartTime
+	}
+	if other.EndTime.After(cz.EndTime) {
+		cz.EndTime = other.EndTime
 	}
-	return
 }
 
-func countPackets(r io.Reader, by string, invalid bool, interval time.Duration) (map[key]rt.Coze, error) {
-	var (
-		getBy  func(vmu.Packet, time.Duration) key
-		missBy func(vmu.Packet, vmu.Packet) uint64
-	)
-	switch by {
-	case Channel, "":
-		getBy = byChannel
-		missBy = func(p, prev vmu.Packet) uint64 {
-			if p.VMUHeader.Sequence < prev.VMUHeader.Sequence {
-				return 0
-			}
-			return uint64(p.VMUHeader.Sequence-prev.VMUHeader.Sequence) - 1
+// Breakdown returns the number of packets per data type as type:count pairs.
+func (c coze) Breakdown() string {
+	var parts []string
+	for i, n := range c.Types {
+		if n == 0 {
+			continue
 		}
-	case Origin:
-		getBy = byOrigin
-		missBy = func(p, prev vmu.Packet) uint64 {
-			if p.DataHeader.Counter < prev.DataHeader.Counter {
-				return 0
-			}
-			return uint64(p.DataHeader.Counter-prev.DataHeader.Counter) - 1
+		var t string
+		if i == 0 {
+			t = "dat"
+		} else {
+			t = vmu.ImageType(i).String()
 		}
-	default:
-		return nil, fmt.Errorf("unknown value %s", by)
+		parts = append(parts, fmt.Sprintf("%s:%d", t, n))
 	}
-	d := vmu.NewDecoder(r, nil)
-	stats := make(map[key]rt.Coze)
-	seen := make(map[key]vmu.Packet)
+	return strings.Join(parts, ",")
+}
+
+// countPackets counts the packets of d by group. Invalid packets that can not
+// be attributed to a group are counted in the group given by group.Unknown.
+func countPackets(d decoder, by group, invalid bool, interval time.Duration, m *vmu.Metrics) (map[key]coze, error) {
+	stats := make(map[key]coze)
+	seen := make(map[key]*vmu.GapTracker)
 	for {
-		p, err := d.Decode(false)
+		p, err := d.DecodeView(false)
 		switch err {
 		case nil, vmu.ErrInvalid:
-			by := getBy(p, interval)
-			cz := stats[by]
+			m.Update(p, err)
+
+			k := by.Key(p, interval)
+			ok := trusted(d, err)
+			if !ok {
+				k = by.Unknown(k)
+			}
+			cz := stats[k]
 
 			cz.Count++
 			cz.Size += uint64(p.VMUHeader.Size)
+			cz.Update(p)
 			if err == vmu.ErrInvalid {
 				cz.Error++
-				if !invalid {
+				if !invalid || !ok {
+					stats[k] = cz
 					continue
 				}
 			}
@@ -273,11 +432,23 @@ func countPackets(r io.Reader, by string, invalid bool, interval time.Duration)
 			if cz.StartTime.IsZero() {
 				cz.First, cz.StartTime = cz.Last, cz.EndTime
 			}
-			if prev, ok := seen[by]; ok {
-				cz.Missing += missBy(p, prev)
+			// trackers follow the packets of a group across buckets
+			tk := k
+			tk.Time = time.Time{}
+			t, ok := seen[tk]
+			if !ok {
+				t = new(vmu.GapTracker)
+				seen[tk] = t
+			}
+			if g, ok := by.Gap(t, p); ok {
+				cz.Missing += uint64(g.Missing())
+				if g.Kind == vmu.GapReset {
+					cz.Resets++
+				}
 			}
-			seen[by], stats[by] = p, cz
+			stats[k] = cz
 		case vmu.ErrSkip:
+			m.Skip()
 		case io.EOF:
 			return stats, nil
 		default:
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/busoc/rt"
+	"github.com/busoc/vmu"
+	"github.com/busoc/vmu/vmutest"
+)
+
+func TestCountUnattributedPackets(t *testing.T) {
+	cfg := vmutest.Default()
+	cfg.Count, cfg.Channels = 10, []uint8{vmu.LRSD}
+
+	var (
+		buf bytes.Buffer
+		g   = vmutest.New(cfg)
+	)
+	for i := 0; ; i++ {
+		_, rec, err := g.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 3 {
+			// an image data header in a science packet
+			rec[vmu.HRDPHeaderLen+vmu.HRDLHeaderLen+vmu.VMUHeaderLen] = 0x20
+		}
+		buf.Write(rec)
+	}
+	d := vmu.NewDecoder(rt.NewReader(&buf), nil)
+	defer d.Close()
+
+	by := groupOrigin | groupUPI
+	stats, err := countPackets(d, by, true, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("%d groups counted, want 2", len(stats))
+	}
+	for k, cz := range stats {
+		switch {
+		case k == by.Unknown(key{}):
+			if cz.Count != 1 || cz.Error != 1 {
+				t.Errorf("unknown group: %d packets (%d invalid), want 1 (1 invalid)", cz.Count, cz.Error)
+			}
+		case cz.Count != 9 || cz.Error != 0:
+			t.Errorf("%02x/%s: %d packets (%d invalid), want 9 (0 invalid)", k.Origin, k.UPI, cz.Count, cz.Error)
+		}
+	}
+}
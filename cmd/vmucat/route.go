@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/busoc/vmu"
+)
+
+// route tells how packets are split in subdirectories of the output directory.
+type route uint8
+
+const (
+	routeStream route = 1 << iota
+	routeUPI
+)
+
+func parseRoute(str string) (route, error) {
+	var r route
+	if str == "" {
+		return r, nil
+	}
+	for _, f := range strings.Split(strings.ToLower(str), ",") {
+		switch strings.TrimSpace(f) {
+		case Stream:
+			r |= routeStream
+		case UPI:
+			r |= routeUPI
+		default:
+			return r, fmt.Errorf("unknown value %s", f)
+		}
+	}
+	return r, nil
+}
+
+type routeKey struct {
+	UPI    string
+	Stream uint16
+	Set    route
+}
+
+func (r route) Key(p vmu.Packet) routeKey {
+	k := routeKey{Set: r}
+	if r&routeUPI != 0 {
+		k.UPI = string(p.DataHeader.UserInfo())
+	}
+	if r&routeStream != 0 {
+		k.Stream = p.DataHeader.Stream
+	}
+	return k
+}
+
+// Dir returns the subdirectory of the packets of k: upi, then stream.
+func (k routeKey) Dir() string {
+	var parts []string
+	if k.Set&routeUPI != 0 {
+		parts = append(parts, k.UPI)
+	}
+	if k.Set&routeStream != 0 {
+		parts = append(parts, strconv.Itoa(int(k.Stream)))
+	}
+	return filepath.Join(parts...)
+}
+
+// DefaultTakeName is the naming template of the files written by take.
+const DefaultTakeName = "{prefix}_{index:%06d}_{date}.dat"
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/busoc/rt"
+	"github.com/busoc/vmu"
+	"github.com/midbel/cli"
+)
+
+func runScience(cmd *cli.Command, args []string) error {
+	var q query
+	q.Register(&cmd.Flag)
+	schemas := cmd.Flag.String("s", "", "comma separated list of science schema files")
+	format := cmd.Flag.String("f", "csv", "output format (csv, json)")
+	keepInvalid := cmd.Flag.Bool("e", false, "keep invalid packets")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	if *schemas == "" {
+		return fmt.Errorf("no schema given")
+	}
+	if err := loadSchemas(*schemas); err != nil {
+		return err
+	}
+	q.Channel = int(vmu.LRSD)
+	filter := q.Query()
+
+	var ws series
+	switch *format {
+	case "csv":
+		ws = &csvSeries{Writer: csv.NewWriter(os.Stdout)}
+	case "json":
+		ws = &jsonSeries{Encoder: json.NewEncoder(os.Stdout)}
+	default:
+		return fmt.Errorf("unknown format %s", *format)
+	}
+
+	mr, err := openArchives(cmd.Flag.Args(), filter)
+	if err != nil {
+		return err
+	}
+	defer mr.Close()
+
+	d := vmu.NewDecoder(rt.NewReader(mr), nil)
+	defer d.Close()
+	for {
+		switch p, err := d.DecodeView(true); err {
+		case nil, vmu.ErrInvalid:
+			if err == vmu.ErrInvalid && !*keepInvalid {
+				continue
+			}
+			if !filter.Keep(p) {
+				continue
+			}
+			if _, ok := vmu.LookupScience(p); !ok {
+				continue
+			}
+			rs, err := p.Records()
+			if err != nil {
+				log.Printf("%s: %s", p.Filename(), err)
+				continue
+			}
+			for _, r := range rs {
+				if err := ws.Write(timeRecord(p, r)); err != nil {
+					return err
+				}
+			}
+		case vmu.ErrSkip:
+		case io.EOF:
+			return ws.Flush()
+		default:
+			return err
+		}
+	}
+}
+
+// timeRecord prefixes r with the acquisition time, the UPI and the counter of
+// the packet it comes from.
+func timeRecord(p vmu.Packet, r vmu.Record) vmu.Record {
+	rec := vmu.Record{
+		{Name: "time", Value: p.DataHeader.Acquisition().Format(rt.TimeFormat)},
+		{Name: "upi", Value: string(p.DataHeader.UserInfo())},
+		{Name: "counter", Value: p.DataHeader.Counter},
+	}
+	return append(rec, r...)
+}
+
+type series interface {
+	Write(vmu.Record) error
+	Flush() error
+}
+
+// csvSeries writes records as csv with the header given by the first record.
+type csvSeries struct {
+	*csv.Writer
+	header bool
+}
+
+func (s *csvSeries) Write(r vmu.Record) error {
+	if !s.header {
+		if err := s.Writer.Write(r.Names()); err != nil {
+			return err
+		}
+		s.header = true
+	}
+	return s.Writer.Write(r.Values())
+}
+
+func (s *csvSeries) Flush() error {
+	s.Writer.Flush()
+	return s.Writer.Error()
+}
+
+// jsonSeries writes one json object per line and per record.
+type jsonSeries struct {
+	*json.Encoder
+}
+
+func (s *jsonSeries) Write(r vmu.Record) error {
+	return s.Encode(r)
+}
+
+func (s *jsonSeries) Flush() error {
+	return nil
+}
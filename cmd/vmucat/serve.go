@@ -0,0 +1,513 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/busoc/vmu"
+	"github.com/midbel/cli"
+)
+
+const serveUI = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>vmucat</title>
+<style>
+body { font-family: sans-serif; font-size: 13px; }
+table { border-collapse: collapse; }
+td, th { padding: 2px 6px; border-bottom: 1px solid #ddd; text-align: left; }
+img { max-height: 64px; }
+</style>
+</head>
+<body>
+<form id="filters">
+  channel <select name="channel"><option value="">all</option><option value="1">vic1</option><option value="2">vic2</option><option value="3">lrsd</option></select>
+  origin <input name="origin" size="4">
+  upi <input name="upi" size="12">
+  from <input name="starts" size="22" placeholder="2006-01-02T15:04:05Z">
+  to <input name="ends" size="22">
+  <button type="button" id="prev">&lt;</button>
+  <button type="submit">show</button>
+  <button type="button" id="next">&gt;</button>
+</form>
+<table>
+<thead><tr><th></th><th>channel</th><th>origin</th><th>acquisition</th><th>sequence</th><th>counter</th><th>upi</th><th>type</th><th>valid</th></tr></thead>
+<tbody id="packets"></tbody>
+</table>
+<script>
+const form = document.getElementById("filters");
+function shift(dir) {
+  const s = Date.parse(form.starts.value), e = Date.parse(form.ends.value);
+  if (isNaN(s) || isNaN(e)) return;
+  const d = (e - s) * dir;
+  form.starts.value = new Date(s + d).toISOString().replace(/\.000Z$/, "Z");
+  form.ends.value = new Date(e + d).toISOString().replace(/\.000Z$/, "Z");
+  show();
+}
+async function show() {
+  const q = new URLSearchParams(new FormData(form));
+  const rs = await fetch("/api/packets?" + q.toString());
+  const body = document.getElementById("packets");
+  body.innerHTML = "";
+  if (!rs.ok) return;
+  for (const p of await rs.json()) {
+    const tr = document.createElement("tr");
+    const ref = "file=" + encodeURIComponent(p.file) + "&offset=" + p.offset;
+    const img = p.channel != "lrsd" ? '<a href="/api/image?' + ref + '"><img loading="lazy" src="/api/image?' + ref + '&format=jpg"></a>' : "";
+    const cells = [img, p.channel, p.origin, p.acquisition, p.sequence, '<a href="/api/packet?' + ref + '">' + p.counter + '</a>', p.upi, p.type, p.valid];
+    tr.innerHTML = cells.map(c => "<td>" + c + "</td>").join("");
+    body.appendChild(tr);
+  }
+}
+form.addEventListener("submit", e => { e.preventDefault(); show(); });
+document.getElementById("prev").onclick = () => shift(-1);
+document.getElementById("next").onclick = () => shift(1);
+show();
+</script>
+</body>
+</html>
+`
+
+func runServe(cmd *cli.Command, args []string) error {
+	addr := cmd.Flag.String("a", "localhost:8080", "listening address")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	s := server{
+		root:    cmd.Flag.Arg(0),
+		indexes: make(map[string]*vmu.Index),
+	}
+	if i, err := os.Stat(s.root); err != nil {
+		return err
+	} else if !i.IsDir() {
+		return fmt.Errorf("%s: not a directory", s.root)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleUI)
+	mux.HandleFunc("/api/packets", s.handlePackets)
+	mux.HandleFunc("/api/packet", s.handlePacket)
+	mux.HandleFunc("/api/image", s.handleImage)
+	mux.HandleFunc("/api/count", s.handleCount)
+	mux.HandleFunc("/api/gaps", s.handleGaps)
+
+	log.Printf("serving %s on http://%s", s.root, *addr)
+	return http.ListenAndServe(*addr, mux)
+}
+
+type server struct {
+	root string
+
+	mu      sync.Mutex
+	indexes map[string]*vmu.Index
+	// indexes being loaded or built, by file
+	pending map[string]*indexCall
+}
+
+// indexCall is the loading of the index of a file shared by the requests
+// needing it. done is closed once x and err are set.
+type indexCall struct {
+	done chan struct{}
+	x    *vmu.Index
+	err  error
+}
+
+type packetInfo struct {
+	File        string    `json:"file"`
+	Offset      int64     `json:"offset"`
+	Channel     string    `json:"channel"`
+	Origin      uint8     `json:"origin"`
+	Sequence    uint32    `json:"sequence"`
+	Counter     uint32    `json:"counter"`
+	Acquisition time.Time `json:"acquisition"`
+	UPI         string    `json:"upi"`
+	Type        string    `json:"type"`
+	Valid       bool      `json:"valid"`
+}
+
+func (s *server) handleUI(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("content-type", "text/html; charset=utf-8")
+	fmt.Fprint(w, serveUI)
+}
+
+// maxLimit is the maximum number of packets returned by /api/packets.
+const maxLimit = 5000
+
+func (s *server) handlePackets(w http.ResponseWriter, r *http.Request) {
+	q, err := parseQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := 500
+	if str := r.URL.Query().Get("limit"); str != "" {
+		if limit, err = strconv.Atoi(str); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if limit <= 0 {
+			http.Error(w, "limit should be positive", http.StatusBadRequest)
+			return
+		}
+		if limit > maxLimit {
+			limit = maxLimit
+		}
+	}
+	files, err := listFiles([]string{s.root})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ps := make([]packetInfo, 0, limit)
+	for _, f := range files {
+		if len(ps) >= limit {
+			break
+		}
+		x, err := s.index(f)
+		if err != nil {
+			continue
+		}
+		rel, _ := filepath.Rel(s.root, f)
+		for _, e := range x.Find(q) {
+			if len(ps) >= limit {
+				break
+			}
+			ps = append(ps, packetInfo{
+				File:        filepath.ToSlash(rel),
+				Offset:      e.Offset,
+				Channel:     string(vmu.WhichChannel(e.Channel)),
+				Origin:      e.Origin,
+				Sequence:    e.Sequence,
+				Counter:     e.Counter,
+				Acquisition: e.Acquisition(),
+				UPI:         string(e.UserInfo()),
+				Type:        entryType(e),
+				Valid:       e.Valid,
+			})
+		}
+	}
+	writeJSONResponse(w, ps)
+}
+
+func (s *server) handlePacket(w http.ResponseWriter, r *http.Request) {
+	p, err := s.packet(r, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSONResponse(w, struct {
+		HRDP        vmu.HRDPHeader `json:"hrdp"`
+		VMU         vmu.VMUHeader  `json:"vmu"`
+		Data        vmu.DataHeader `json:"data"`
+		UPI         string         `json:"upi"`
+		Timestamp   time.Time      `json:"timestamp"`
+		Acquisition time.Time      `json:"acquisition"`
+		Archive     time.Time      `json:"archive"`
+		Realtime    bool           `json:"realtime"`
+		Filename    string         `json:"filename"`
+	}{
+		HRDP:        p.HRDPHeader,
+		VMU:         p.VMUHeader,
+		Data:        p.DataHeader,
+		UPI:         string(p.DataHeader.UserInfo()),
+		Timestamp:   p.VMUHeader.Timestamp(),
+		Acquisition: p.DataHeader.Acquisition(),
+		Archive:     p.HRDPHeader.Archive(),
+		Realtime:    p.IsRealtime(),
+		Filename:    p.Filename(),
+	})
+}
+
+func (s *server) handleImage(w http.ResponseWriter, r *http.Request) {
+	p, err := s.packet(r, true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	switch p.DataHeader.Type {
+	case vmu.JPEG:
+		w.Header().Set("content-type", "image/jpeg")
+	case vmu.PNG:
+		w.Header().Set("content-type", "image/png")
+	default:
+		if format == "jpg" || format == "jpeg" {
+			w.Header().Set("content-type", "image/jpeg")
+		} else {
+			w.Header().Set("content-type", "image/png")
+		}
+	}
+	if err := p.ExportImage(w, format); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	}
+}
+
+func (s *server) handleCount(w http.ResponseWriter, r *http.Request) {
+	g, interval, err := parseReport(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	d, err := s.entries()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	stats, err := countPackets(d, g, true, interval, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	writeJSON(w, g, fillRows(stats, interval), interval > 0, false, coze{})
+}
+
+func (s *server) handleGaps(w http.ResponseWriter, r *http.Request) {
+	g, _, err := parseReport(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var duration time.Duration
+	if str := r.URL.Query().Get("duration"); str != "" {
+		if duration, err = time.ParseDuration(str); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	d, err := s.entries()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	type item struct {
+		Label   string    `json:"group"`
+		Kind    string    `json:"kind"`
+		Last    uint32    `json:"last"`
+		First   uint32    `json:"first"`
+		Missing uint32    `json:"missing"`
+		Starts  time.Time `json:"starts"`
+		Ends    time.Time `json:"ends"`
+	}
+	gaps := make([]item, 0, 64)
+	err = findGaps(d, g, true, duration, nil, func(p gap) error {
+		gaps = append(gaps, item{
+			Label:   p.key.Label(g),
+			Kind:    p.Kind.String(),
+			Last:    p.Last,
+			First:   p.First,
+			Missing: p.Missing(),
+			Starts:  p.Starts,
+			Ends:    p.Ends,
+		})
+		return nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSONResponse(w, gaps)
+}
+
+// index returns the index of file, from its index file when it is up to date
+// or built and cached otherwise.
+func (s *server) index(file string) (*vmu.Index, error) {
+	i, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	if c, ok := s.indexes[file]; ok && c.ModTime.Equal(i.ModTime()) && c.Size == i.Size() {
+		s.mu.Unlock()
+		return c, nil
+	}
+	if c, ok := s.pending[file]; ok {
+		s.mu.Unlock()
+		<-c.done
+		return c.x, c.err
+	}
+	if s.pending == nil {
+		s.pending = make(map[string]*indexCall)
+	}
+	c := &indexCall{done: make(chan struct{})}
+	s.pending[file] = c
+	s.mu.Unlock()
+
+	// the index is built without holding the lock: requests for other files
+	// are not blocked and the ones for file wait for c.
+	c.x, c.err = vmu.LoadIndex(file)
+	if c.err != nil || len(c.x.Entries) == 0 {
+		c.x, c.err = vmu.BuildIndex(file)
+	}
+
+	s.mu.Lock()
+	delete(s.pending, file)
+	if c.err == nil {
+		s.indexes[file] = c.x
+	}
+	s.mu.Unlock()
+	close(c.done)
+	return c.x, c.err
+}
+
+// entries returns the headers of every packet of the archives of s, in the
+// order of the archives, from their indexes.
+func (s *server) entries() (*entryDecoder, error) {
+	files, err := listFiles([]string{s.root})
+	if err != nil {
+		return nil, err
+	}
+	var d entryDecoder
+	for _, f := range files {
+		x, err := s.index(f)
+		if err != nil {
+			continue
+		}
+		d.entries = append(d.entries, x.Entries...)
+	}
+	return &d, nil
+}
+
+// entryDecoder decodes packets from index entries. Packets have no payload.
+type entryDecoder struct {
+	entries []vmu.IndexEntry
+}
+
+func (d *entryDecoder) DecodeView(_ bool) (vmu.Packet, error) {
+	if len(d.entries) == 0 {
+		return vmu.Packet{}, io.EOF
+	}
+	e := d.entries[0]
+	d.entries = d.entries[1:]
+	if !e.Valid {
+		return e.Header(), vmu.ErrInvalid
+	}
+	return e.Header(), nil
+}
+
+// packet reads the packet at the offset and in the file given in the query
+// of r. The file should be relative to the root of the server.
+func (s *server) packet(r *http.Request, data bool) (vmu.Packet, error) {
+	var p vmu.Packet
+	q := r.URL.Query()
+	file := filepath.Join(s.root, filepath.FromSlash(filepath.Clean("/"+q.Get("file"))))
+	offset, err := strconv.ParseInt(q.Get("offset"), 10, 64)
+	if err != nil {
+		return p, err
+	}
+	x, err := s.index(file)
+	if err != nil {
+		return p, err
+	}
+	for _, e := range x.Entries {
+		if e.Offset != offset {
+			continue
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return p, err
+		}
+		defer f.Close()
+
+		p, err = x.Packet(f, e, data)
+		if err == vmu.ErrInvalid {
+			err = nil
+		}
+		return p, err
+	}
+	return p, fmt.Errorf("no packet at offset %d", offset)
+}
+
+func parseQuery(r *http.Request) (vmu.Query, error) {
+	var (
+		q   vmu.Query
+		n   uint64
+		err error
+		vs  = r.URL.Query()
+	)
+	parseUint := func(name string, bits int) (uint64, error) {
+		str := vs.Get(name)
+		if str == "" {
+			return 0, nil
+		}
+		return strconv.ParseUint(str, 0, bits)
+	}
+	if n, err = parseUint("channel", 8); err != nil {
+		return q, err
+	}
+	q.Channel = uint8(n)
+	if n, err = parseUint("origin", 8); err != nil {
+		return q, err
+	}
+	q.Origin = uint8(n)
+	parseCounter := func(name string) (*uint32, error) {
+		str := vs.Get(name)
+		if str == "" {
+			return nil, nil
+		}
+		n, err := strconv.ParseUint(str, 0, 32)
+		if err != nil {
+			return nil, err
+		}
+		c := uint32(n)
+		return &c, nil
+	}
+	if q.First, err = parseCounter("first"); err != nil {
+		return q, err
+	}
+	if q.Last, err = parseCounter("last"); err != nil {
+		return q, err
+	}
+	if str := vs.Get("starts"); str != "" {
+		if q.Starts, err = time.Parse(time.RFC3339, str); err != nil {
+			return q, err
+		}
+	}
+	if str := vs.Get("ends"); str != "" {
+		if q.Ends, err = time.Parse(time.RFC3339, str); err != nil {
+			return q, err
+		}
+	}
+	q.UPI = vs.Get("upi")
+	return q, nil
+}
+
+func parseReport(r *http.Request) (group, time.Duration, error) {
+	var interval time.Duration
+	g, err := parseGroup(r.URL.Query().Get("by"))
+	if err != nil {
+		return g, interval, err
+	}
+	if str := r.URL.Query().Get("interval"); str != "" {
+		interval, err = time.ParseDuration(str)
+	}
+	return g, interval, err
+}
+
+func entryType(e vmu.IndexEntry) string {
+	if e.Channel == vmu.LRSD {
+		return "dat"
+	}
+	return e.Type.String()
+}
+
+func writeJSONResponse(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("content-type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
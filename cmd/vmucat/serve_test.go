@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/busoc/rt"
+	"github.com/busoc/vmu"
+	"github.com/busoc/vmu/vmutest"
+)
+
+func testServer(t *testing.T, cfg vmutest.Config) (*server, []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, err := vmutest.New(cfg).WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "rt_00_04.dat"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := &server{
+		root:    dir,
+		indexes: make(map[string]*vmu.Index),
+	}
+	return s, buf.Bytes()
+}
+
+func TestServePacketsLimit(t *testing.T) {
+	cfg := vmutest.Default()
+	cfg.Count = 20
+	s, _ := testServer(t, cfg)
+
+	for _, str := range []string{"-1", "0", "abc"} {
+		w := httptest.NewRecorder()
+		s.handlePackets(w, httptest.NewRequest("GET", "/api/packets?limit="+str, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("limit %s: got status %d, want %d", str, w.Code, http.StatusBadRequest)
+		}
+	}
+	w := httptest.NewRecorder()
+	s.handlePackets(w, httptest.NewRequest("GET", "/api/packets?limit=5", nil))
+	var ps []packetInfo
+	if err := json.NewDecoder(w.Body).Decode(&ps); err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 5 {
+		t.Errorf("%d packets returned, want 5", len(ps))
+	}
+}
+
+func TestServeCountFromIndexes(t *testing.T) {
+	cfg := vmutest.Default()
+	cfg.Count, cfg.Gaps, cfg.Invalid = 200, 0.05, 0.05
+	s, buf := testServer(t, cfg)
+
+	for _, by := range []group{groupChannel, groupOrigin | groupUPI} {
+		d := vmu.NewDecoder(rt.NewReader(bytes.NewReader(buf)), nil)
+		want, err := countPackets(d, by, true, 0, nil)
+		d.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		x, err := s.entries()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := countPackets(x, by, true, 0, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("group %d: counts from indexes differ\ngot:  %+v\nwant: %+v", by, got, want)
+		}
+	}
+}
+
+func TestServeIndexOnce(t *testing.T) {
+	cfg := vmutest.Default()
+	cfg.Count = 200
+	s, _ := testServer(t, cfg)
+	file := filepath.Join(s.root, "rt_00_04.dat")
+
+	var (
+		wg sync.WaitGroup
+		xs = make([]*vmu.Index, 8)
+	)
+	for i := range xs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			x, err := s.index(file)
+			if err != nil {
+				t.Error(err)
+			}
+			xs[i] = x
+		}(i)
+	}
+	wg.Wait()
+	for i, x := range xs {
+		if x == nil || x != xs[0] {
+			t.Fatalf("request %d: index built again", i)
+		}
+	}
+	if len(s.pending) != 0 {
+		t.Errorf("%d indexes still pending", len(s.pending))
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"container/heap"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"os"
@@ -12,47 +14,399 @@ import (
 	"github.com/busoc/vmu"
 	"github.com/midbel/cli"
 	"github.com/midbel/roll"
+	"github.com/midbel/xxh"
+)
+
+const (
+	framingHRDP = "hrdp"
+	framingHRDL = "hrdl"
 )
 
 func runMerge(cmd *cli.Command, args []string) error {
+	dedup := cmd.Flag.Bool("u", false, "drop duplicate packets")
+	report := cmd.Flag.String("r", "", "write merge decisions to report")
+	framing := cmd.Flag.String("f", "", "framing of final file (hrdp, hrdl)")
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
-	files := cmd.Flag.Args()
-	w, err := os.Create(cmd.Flag.Arg(0))
+	if cmd.Flag.NArg() < 2 {
+		return fmt.Errorf("no input files given")
+	}
+	files := cmd.Flag.Args()[1:]
+
+	target := strings.ToLower(*framing)
+	switch target {
+	case "", framingHRDP, framingHRDL:
+	default:
+		return fmt.Errorf("unknown framing %s", *framing)
+	}
+	target, files, err := checkFraming(target, files)
+	if err != nil {
+		return err
+	}
+
+	var srcs sources
+	defer func() { srcs.Close() }()
+	for i, file := range files {
+		src, err := openSource(file, i)
+		if err != nil {
+			return err
+		}
+		srcs = append(srcs, src)
+	}
+
+	f, err := os.Create(cmd.Flag.Arg(0))
 	if err != nil {
 		return err
 	}
-	defer w.Close()
+	defer f.Close()
 
-	cmp := func(o, other rt.Offset) bool {
-		if o.Time.Equal(other.Time) {
-			if o.Pid != other.Pid {
-				return o.Len < other.Len
+	var (
+		w io.Writer = f
+		u *deduper
+	)
+	if *dedup {
+		var log io.Writer = io.Discard
+		if *report != "" {
+			r, err := os.Create(*report)
+			if err != nil {
+				return err
 			}
-			return o.Sequence < other.Sequence
+			defer r.Close()
+			log = r
+		}
+		u = uniq(f, log)
+		w = u
+	}
+	err = srcs.Merge(func(r record) error {
+		body := r.Body
+		if target == framingHRDL && r.HRDP {
+			body = body[vmu.HRDPHeaderLen:]
+		}
+		_, err := w.Write(body)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if u != nil {
+		return u.Flush()
+	}
+	return nil
+}
+
+type record struct {
+	Body []byte
+	HRDP bool
+	// hash of the HRDL frame and index of the input file, used to order
+	// copies of the same packet.
+	Hash   uint64
+	Source int
+	vmu.VMUHeader
+}
+
+// Less orders records by VMU time, channel and sequence. Copies of the same
+// packet are ordered by the hash of their HRDL frame then by input file so
+// that the order is total and copies are always next to each other.
+func (r record) Less(other record) bool {
+	if t, o := r.Timestamp(), other.Timestamp(); !t.Equal(o) {
+		return t.Before(o)
+	}
+	if r.Channel != other.Channel {
+		return r.Channel < other.Channel
+	}
+	if r.Sequence != other.Sequence {
+		return r.Sequence < other.Sequence
+	}
+	if r.Hash != other.Hash {
+		return r.Hash < other.Hash
+	}
+	return r.Source < other.Source
+}
+
+// checkFraming returns the framing of the final file and the input files to
+// merge, without the empty ones. Without target, all the inputs should have the
+// same framing. Every input can be written as bare HRDL frames but HRDL inputs
+// can not be written with a HRDP header.
+func checkFraming(target string, files []string) (string, []string, error) {
+	var (
+		keep  []string
+		first string
+	)
+	for _, file := range files {
+		f, err := detectFraming(file)
+		if err != nil {
+			return "", nil, err
+		}
+		if f == "" {
+			continue
 		}
-		return o.Time.Before(other.Time)
+		switch {
+		case target == framingHRDP && f == framingHRDL:
+			return "", nil, fmt.Errorf("%s: bare HRDL packets can not be written with a HRDP header (use -f hrdl to merge mixed inputs)", file)
+		case target == "" && len(keep) > 0 && f != first:
+			return "", nil, fmt.Errorf("%s: %s framing found but %s has %s framing (use -f hrdl to merge mixed inputs)", file, f, keep[0], first)
+		}
+		if len(keep) == 0 {
+			first = f
+		}
+		keep = append(keep, file)
+	}
+	if len(keep) == 0 {
+		return "", nil, fmt.Errorf("no packets in input files")
+	}
+	if target == "" {
+		target = first
+	}
+	return target, keep, nil
+}
+
+// detectFraming reports whether the packets of file start with a HRDP header
+// (USOC archives) or directly with the HRDL syncword (output of take). Empty
+// files have no framing.
+func detectFraming(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	word := make([]byte, 4)
+	switch _, err := io.ReadFull(f, word); err {
+	case nil:
+	case io.EOF:
+		return "", nil
+	default:
+		return "", fmt.Errorf("%s: %s", file, err)
+	}
+	if binary.BigEndian.Uint32(word) == vmu.Syncword {
+		return framingHRDL, nil
 	}
+	return framingHRDP, nil
+}
 
-	return rt.MergeFiles(files[1:], w, func(bs []byte) (rt.Offset, error) {
-		var o rt.Offset
-		if len(bs) < vmu.HRDPHeaderLen+vmu.VMUHeaderLen {
-			return o, rt.ErrSkip
+// source reads the records of one input file. The body of the current record
+// is only valid until the next call to Next.
+type source struct {
+	io.Closer
+	file   string
+	index  int
+	inner  io.Reader
+	offset int
+	buffer *[]byte
+
+	curr record
+}
+
+func openSource(file string, index int) (*source, error) {
+	framing, err := detectFraming(file)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	s := source{
+		Closer: f,
+		file:   file,
+		index:  index,
+		buffer: vmu.AcquireBuffer(),
+	}
+	if framing == framingHRDL {
+		s.inner = vmu.NewHRDLReader(f)
+	} else {
+		s.inner, s.offset = rt.NewReader(f), vmu.HRDPHeaderLen
+	}
+	return &s, nil
+}
+
+// Close closes the input file of s and gives its buffer back to the pool.
+func (s *source) Close() error {
+	vmu.ReleaseBuffer(s.buffer)
+	s.buffer = nil
+	return s.Closer.Close()
+}
+
+// Next reads the next record of s. It returns false at the end of the file.
+// A truncated last packet ends the file too.
+func (s *source) Next() (bool, error) {
+	for {
+		n, err := s.inner.Read(*s.buffer)
+		switch err {
+		case nil:
+		case vmu.ErrSkip:
+			continue
+		case io.EOF, io.ErrUnexpectedEOF:
+			return false, nil
+		default:
+			return false, fmt.Errorf("%s: %s", s.file, err)
 		}
-		v, err := vmu.DecodeVMU(bs[vmu.HRDPHeaderLen:])
+		r, err := newRecord((*s.buffer)[:n], s.offset, s.index)
 		if err != nil {
-			return o, err
+			continue
 		}
-		o.Cmp = cmp
+		s.curr = r
+		return true, nil
+	}
+}
 
-		o.Pid = uint(v.Channel)
-		o.Sequence = uint(v.Sequence)
-		o.Len = uint(v.Size)
-		o.Time = v.Timestamp()
+// newRecord returns the record of body read from the input file index. The
+// HRDL frame starts at offset in body.
+func newRecord(body []byte, offset, index int) (record, error) {
+	if len(body) < offset+vmu.HRDLHeaderLen+vmu.VMUHeaderLen {
+		return record{}, vmu.ErrSkip
+	}
+	v, err := vmu.DecodeVMU(body[offset:])
+	if err != nil {
+		return record{}, err
+	}
+	r := record{
+		Body:      body,
+		HRDP:      offset > 0,
+		Hash:      xxh.Sum64(body[offset:], 0),
+		Source:    index,
+		VMUHeader: v,
+	}
+	return r, nil
+}
 
-		return o, nil
-	})
+// sources merges the records of its inputs with a heap holding the current
+// record of each input.
+type sources []*source
+
+func (s sources) Len() int           { return len(s) }
+func (s sources) Less(i, j int) bool { return s[i].curr.Less(s[j].curr) }
+func (s sources) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func (s *sources) Push(x interface{}) { *s = append(*s, x.(*source)) }
+
+func (s *sources) Pop() interface{} {
+	old := *s
+	x := old[len(old)-1]
+	*s = old[:len(old)-1]
+	return x
+}
+
+// Merge calls fn with the records of all the inputs of s in order. Each input
+// is read once, so only the current record of each input is held in memory.
+func (s sources) Merge(fn func(record) error) error {
+	var h sources
+	for _, src := range s {
+		ok, err := src.Next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			h = append(h, src)
+		}
+	}
+	heap.Init(&h)
+	for h.Len() > 0 {
+		src := h[0]
+		if err := fn(src.curr); err != nil {
+			return err
+		}
+		ok, err := src.Next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+	return nil
+}
+
+func (s sources) Close() error {
+	for _, src := range s {
+		src.Close()
+	}
+	return nil
+}
+
+type candidate struct {
+	Body  []byte
+	Valid bool
+	vmu.Packet
+}
+
+// deduper drops the duplicate packets written to it. Packets are expected to
+// be written in merge order so that copies of the same packet (same channel,
+// sequence and timestamp) are written one after the other.
+type deduper struct {
+	inner io.Writer
+	log   io.Writer
+
+	pending []candidate
+}
+
+func uniq(w, log io.Writer) *deduper {
+	return &deduper{inner: w, log: log}
+}
+
+func (d *deduper) Write(bs []byte) (int, error) {
+	p, err := vmu.DecodePacket(bs, false)
+	if err != nil && err != vmu.ErrInvalid {
+		if err := d.Flush(); err != nil {
+			return 0, err
+		}
+		return d.inner.Write(bs)
+	}
+	if len(d.pending) > 0 && !d.pending[0].Same(p) {
+		if err := d.Flush(); err != nil {
+			return 0, err
+		}
+	}
+	c := candidate{
+		Body:   append([]byte(nil), bs...),
+		Valid:  err == nil,
+		Packet: p,
+	}
+	d.pending = append(d.pending, c)
+	return len(bs), nil
+}
+
+// Flush writes the packet kept from the pending copies: exact duplicates
+// (same checksum) are dropped and, when copies have different contents, the
+// first copy with a valid checksum is preferred.
+func (d *deduper) Flush() error {
+	if len(d.pending) == 0 {
+		return nil
+	}
+	defer func() { d.pending = d.pending[:0] }()
+
+	var keep int
+	for i, c := range d.pending {
+		if !d.pending[keep].Valid && c.Valid {
+			keep = i
+		}
+	}
+	for i, c := range d.pending {
+		if i == keep {
+			continue
+		}
+		var reason string
+		switch {
+		case c.Sum == d.pending[keep].Sum && c.Valid == d.pending[keep].Valid:
+			reason = "duplicate"
+		case !c.Valid:
+			reason = "conflict (invalid checksum)"
+		default:
+			reason = "conflict"
+		}
+		fmt.Fprintf(d.log, "drop %s %d %s %08x: %s\n", vmu.WhichChannel(c.VMUHeader.Channel), c.VMUHeader.Sequence, c.VMUHeader.Timestamp().Format(rt.TimeFormat), c.Sum, reason)
+	}
+	_, err := d.inner.Write(d.pending[keep].Body)
+	return err
+}
+
+// Same reports whether c and p are copies of the same packet.
+func (c candidate) Same(p vmu.Packet) bool {
+	v := c.VMUHeader
+	return v.Channel == p.VMUHeader.Channel && v.Sequence == p.VMUHeader.Sequence && v.Coarse == p.VMUHeader.Coarse && v.Fine == p.VMUHeader.Fine
 }
 
 func runTake(cmd *cli.Command, args []string) error {
@@ -64,17 +418,24 @@ func runTake(cmd *cli.Command, args []string) error {
 	cmd.Flag.IntVar(&t.Size, "s", 0, "size")
 	cmd.Flag.IntVar(&t.Count, "c", 0, "count")
 	cmd.Flag.BoolVar(&t.Invalid, "e", false, "invalid")
+	cmd.Flag.StringVar(&t.Name, "t", DefaultTakeName, "naming template of files ({prefix}, {index}, {date} and packet placeholders)")
+	by := cmd.Flag.String("r", "", "split files by stream and/or upi")
 
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
+	r, err := parseRoute(*by)
+	if err != nil {
+		return err
+	}
+	t.Route = r
 
 	dirs := make([]string, cmd.Flag.NArg()-1)
 	for i := 1; i < cmd.Flag.NArg(); i++ {
 		dirs[i-1] = cmd.Flag.Arg(i)
 	}
 
-	err := t.Sort(cmd.Flag.Arg(0), dirs)
+	err = t.Sort(cmd.Flag.Arg(0), dirs)
 	if err == nil {
 		fmt.Fprintf(os.Stdout, "%d packets written (%d skipped, %dKB)\n", t.state.Count, t.state.Skipped, t.state.Size>>10)
 	}
@@ -88,39 +449,69 @@ type taker struct {
 	Size     int
 	Count    int
 	Invalid  bool
+	Name     string
+	Route    route
 
 	state struct {
 		Count   int
 		Skipped int
 		Size    int
-		Stamp   time.Time
 	}
 }
 
+// rolling is the set of files of one route.
+type rolling struct {
+	*roll.Roller
+	Stamp time.Time
+	// headers of the packet being written, used to name the file opened for it
+	Packet vmu.Packet
+}
+
+// Set records the headers of p as the ones of the packet being written.
+func (r *rolling) Set(p vmu.Packet) {
+	p.Data = nil
+	r.Packet = p
+}
+
 func (t *taker) Sort(datadir string, dirs []string) error {
-	mr, err := rt.Browse(dirs, true)
+	mr, err := browseArchives(dirs)
 	if err != nil {
 		return err
 	}
 	defer mr.Close()
 
-	wc, err := roll.Roll(t.Open(datadir), roll.WithThreshold(t.Size, t.Count))
-	if err != nil {
-		return err
-	}
-	defer wc.Close()
+	files := make(map[routeKey]*rolling)
+	defer func() {
+		for _, wc := range files {
+			wc.Close()
+		}
+	}()
 
 	d := vmu.NewDecoder(rt.NewReader(mr), vmu.WithChannel(t.Channel, !t.Invalid))
+	defer d.Close()
 	for {
-		switch p, err := d.Decode(true); err {
+		switch p, err := d.DecodeView(true); err {
 		case nil:
+			k := t.Route.Key(p)
+			wc, ok := files[k]
+			if !ok {
+				wc = new(rolling)
+				wc.Set(p)
+				c, err := roll.Roll(t.Open(filepath.Join(datadir, k.Dir()), wc), roll.WithThreshold(t.Size, t.Count))
+				if err != nil {
+					return err
+				}
+				wc.Roller = c
+				files[k] = wc
+			}
+			wc.Set(p)
 			if t.Interval >= rt.Five {
 				w := p.Timestamp()
-				if !t.state.Stamp.IsZero() && w.Sub(t.state.Stamp) >= t.Interval {
+				if !wc.Stamp.IsZero() && w.Sub(wc.Stamp) >= t.Interval {
 					wc.Rotate()
 				}
-				if t.state.Stamp.IsZero() || w.Sub(t.state.Stamp) >= t.Interval {
-					t.state.Stamp = w
+				if wc.Stamp.IsZero() || w.Sub(wc.Stamp) >= t.Interval {
+					wc.Stamp = w
 				}
 			}
 			if buf, err := p.Marshal(); err == nil {
@@ -143,7 +534,10 @@ func (t *taker) Sort(datadir string, dirs []string) error {
 	}
 }
 
-func (t *taker) Open(dir string) roll.NextFunc {
+// Open returns the function creating the files of a route in dir. The
+// placeholders of the naming template related to packets are given by the
+// packet of r being written when a file is opened.
+func (t *taker) Open(dir string, r *rolling) roll.NextFunc {
 	if t.Prefix == "" {
 		if t.Channel != 0 {
 			t.Prefix = string(vmu.WhichChannel(uint8(t.Channel)))
@@ -153,12 +547,24 @@ func (t *taker) Open(dir string) roll.NextFunc {
 	} else {
 		t.Prefix = strings.TrimRight(t.Prefix, "_-")
 	}
+	if t.Name == "" {
+		t.Name = DefaultTakeName
+	}
+	n, err := vmu.ParseNaming(t.Name, "prefix", "index", "date")
 	return func(i int, w time.Time) (io.WriteCloser, []io.Closer, error) {
-		if err := os.MkdirAll(dir, 0755); err != nil {
+		if err != nil {
+			return nil, nil, err
+		}
+		extra := map[string]interface{}{
+			"prefix": t.Prefix,
+			"index":  i - 1,
+			"date":   w,
+		}
+		file := filepath.Join(dir, n.Format(r.Packet, extra))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
 			return nil, nil, err
 		}
-		file := fmt.Sprintf("%s_%06d_%s.dat", t.Prefix, i-1, w.Format("20060102_150405"))
-		wc, err := os.Create(filepath.Join(dir, file))
+		wc, err := os.Create(file)
 		return wc, nil, err
 	}
 }
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/busoc/vmu"
+	"github.com/busoc/vmu/vmutest"
+)
+
+func TestMergeDropsShuffledDuplicates(t *testing.T) {
+	cfg := vmutest.Default()
+	cfg.Count, cfg.HRDL = 60, true
+	// two packets acquired at the same time on every channel
+	cfg.Interval = 0
+
+	var (
+		want [][]byte
+		rs   []record
+		rnd  = rand.New(rand.NewSource(1))
+	)
+	g := vmutest.New(cfg)
+	for {
+		_, buf, err := g.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, buf)
+		for i, n := 0, 1+rnd.Intn(3); i < n; i++ {
+			r, err := newRecord(append([]byte(nil), buf...), 0, rnd.Intn(3))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rs = append(rs, r)
+		}
+		if rnd.Intn(4) == 0 {
+			// a copy with the same sequence but a corrupted payload
+			bad := append([]byte(nil), buf...)
+			bad[len(bad)-vmu.HRDLTrailerLen-1] ^= 0xFF
+			r, err := newRecord(bad, 0, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rs = append(rs, r)
+		}
+	}
+	rnd.Shuffle(len(rs), func(i, j int) { rs[i], rs[j] = rs[j], rs[i] })
+	sort.Slice(rs, func(i, j int) bool { return rs[i].Less(rs[j]) })
+
+	var (
+		out bytes.Buffer
+		log strings.Builder
+	)
+	u := uniq(&out, &log)
+	for _, r := range rs {
+		if _, err := u.Write(r.Body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := u.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got [][]byte
+	r := vmu.NewHRDLReader(&out)
+	for {
+		buf := make([]byte, vmu.BufferSize)
+		n, err := r.Read(buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, buf[:n])
+	}
+	if len(got) != len(want) {
+		t.Fatalf("%d packets written, want %d\n%s", len(got), len(want), log.String())
+	}
+	seen := make(map[string]bool)
+	for _, w := range want {
+		seen[string(w)] = true
+	}
+	for i, g := range got {
+		if !seen[string(g)] {
+			t.Errorf("packet %d: not one of the valid packets", i)
+		}
+		delete(seen, string(g))
+	}
+}
+
+func TestRecordOrderIsTotal(t *testing.T) {
+	cfg := vmutest.Default()
+	cfg.Count, cfg.HRDL, cfg.Interval = 30, true, 0
+
+	var rs []record
+	g := vmutest.New(cfg)
+	for i := 0; ; i++ {
+		_, buf, err := g.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := newRecord(buf, 0, i%2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs = append(rs, r)
+	}
+	for _, a := range rs {
+		if a.Less(a) {
+			t.Fatalf("record less than itself")
+		}
+		for _, b := range rs {
+			if a.Less(b) && b.Less(a) {
+				t.Fatalf("records ordered both ways")
+			}
+			for _, c := range rs {
+				if a.Less(b) && b.Less(c) && !a.Less(c) {
+					t.Fatalf("order is not transitive")
+				}
+			}
+		}
+	}
+}
+
+func TestCheckFraming(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, hrdl bool) string {
+		cfg := vmutest.Default()
+		cfg.Count, cfg.HRDL = 3, hrdl
+
+		file := filepath.Join(dir, name)
+		f, err := os.Create(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if name != "empty" {
+			if _, err := vmutest.New(cfg).WriteTo(f); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return file
+	}
+	var (
+		hrdp  = write("hrdp", false)
+		hrdl  = write("hrdl", true)
+		empty = write("empty", false)
+	)
+	data := []struct {
+		Target string
+		Files  []string
+		Want   string
+		Err    bool
+	}{
+		{Files: []string{hrdp, hrdp}, Want: framingHRDP},
+		{Files: []string{hrdl, hrdl}, Want: framingHRDL},
+		{Files: []string{hrdp, hrdl}, Err: true},
+		{Files: []string{hrdl, hrdp}, Err: true},
+		{Files: []string{empty, hrdl, empty}, Want: framingHRDL},
+		{Files: []string{empty}, Err: true},
+		{Target: framingHRDL, Files: []string{hrdp, hrdl}, Want: framingHRDL},
+		{Target: framingHRDL, Files: []string{hrdl, hrdp}, Want: framingHRDL},
+		{Target: framingHRDP, Files: []string{hrdp, hrdl}, Err: true},
+		{Target: framingHRDP, Files: []string{hrdl, hrdp}, Err: true},
+	}
+	for i, d := range data {
+		got, files, err := checkFraming(d.Target, d.Files)
+		if d.Err {
+			if err == nil {
+				t.Errorf("%d: no error for %s inputs", i, d.Target)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+			continue
+		}
+		if got != d.Want {
+			t.Errorf("%d: got framing %s, want %s", i, got, d.Want)
+		}
+		for _, f := range files {
+			if f == empty {
+				t.Errorf("%d: empty input kept", i)
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/busoc/rt"
+	"github.com/busoc/vmu"
+)
+
+const htmlSummary = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>vmucat count</title></head>
+<body>
+<table>
+<thead><tr>{{range .Headers}}<th>{{.}}</th>{{end}}</tr></thead>
+<tbody>
+{{range .Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</tbody>
+</table>
+</body>
+</html>
+`
+
+// writeSummary writes the statistics of count as a table in file. The table
+// is written in HTML when file has a .html or .htm extension and in Markdown
+// otherwise.
+func writeSummary(file string, g group, rows []row) error {
+	w, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	headers, cells := summaryRows(g, rows)
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".html", ".htm":
+		t, err := template.New("summary").Parse(htmlSummary)
+		if err != nil {
+			return err
+		}
+		return t.Execute(w, struct {
+			Headers []string
+			Rows    [][]string
+		}{headers, cells})
+	default:
+		return writeMarkdown(w, headers, cells)
+	}
+}
+
+func writeMarkdown(w io.Writer, headers []string, rows [][]string) error {
+	sep := make([]string, len(headers))
+	for i := range sep {
+		sep[i] = "---"
+	}
+	if _, err := fmt.Fprintf(w, "| %s |\n| %s |\n", strings.Join(headers, " | "), strings.Join(sep, " | ")); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		if _, err := fmt.Fprintf(w, "| %s |\n", strings.Join(r, " | ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func summaryRows(g group, rows []row) ([]string, [][]string) {
+	var headers []string
+	if g.Has(groupChannel) {
+		headers = append(headers, "channel")
+	}
+	if g.Has(groupOrigin) {
+		headers = append(headers, "origin")
+	}
+	if g.Has(groupUPI) {
+		headers = append(headers, "upi")
+	}
+	if len(rows) > 0 && !rows[0].Time.IsZero() {
+		headers = append(headers, "time")
+	}
+	headers = append(headers, "count", "missing", "errors", "resets", "bytes", "first", "last", "types")
+
+	cells := make([][]string, 0, len(rows))
+	for _, cz := range rows {
+		var r []string
+		if g.Has(groupChannel) {
+			r = append(r, string(vmu.WhichChannel(cz.Channel)))
+		}
+		if g.Has(groupOrigin) {
+			r = append(r, fmt.Sprintf("%02x", cz.Origin))
+		}
+		if g.Has(groupUPI) {
+			r = append(r, cz.UPI)
+		}
+		if !cz.Time.IsZero() {
+			r = append(r, cz.Time.Format(rt.TimeFormat))
+		}
+		r = append(r,
+			fmt.Sprint(cz.Count),
+			fmt.Sprint(cz.Missing),
+			fmt.Sprint(cz.Error),
+			fmt.Sprint(cz.Resets),
+			fmt.Sprint(cz.Size),
+			cz.StartTime.Format(rt.TimeFormat),
+			cz.EndTime.Format(rt.TimeFormat),
+			cz.Breakdown(),
+		)
+		cells = append(cells, r)
+	}
+	return headers, cells
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/busoc/vmu"
+	"github.com/busoc/vmu/vmutest"
+)
+
+func TestTakeNamesFilesFromTheirFirstPacket(t *testing.T) {
+	cfg := vmutest.Default()
+	cfg.Count, cfg.Channels = 20, []uint8{vmu.LRSD}
+
+	src := t.TempDir()
+	f, err := os.Create(filepath.Join(src, "rt_00_04.dat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vmutest.New(cfg).WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	dst := t.TempDir()
+	tk := taker{Count: 3, Name: "{prefix}_{index:%02d}_{sequence:%04d}.dat"}
+	if err := tk.Sort(dst, []string{src}); err != nil {
+		t.Fatal(err)
+	}
+	files, err := filepath.Glob(filepath.Join(dst, "*.dat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (cfg.Count + tk.Count - 1) / tk.Count; len(files) != want {
+		t.Fatalf("%d files written, want %d", len(files), want)
+	}
+	for i, file := range files {
+		r, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// take writes bare HRDL frames
+		buf := make([]byte, vmu.BufferSize)
+		n, err := vmu.NewHRDLReader(r).Read(buf)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec, err := newRecord(buf[:n], 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := fmt.Sprintf("rt_%02d_%04d.dat", i, rec.Sequence)
+		if got := filepath.Base(file); got != want {
+			t.Errorf("file %d: got %s, want %s", i, got, want)
+		}
+	}
+}
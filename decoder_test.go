@@ -0,0 +1,20 @@
+package vmu
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDecoderClosed(t *testing.T) {
+	d := NewDecoder(bytes.NewReader(nil), nil)
+	d.Close()
+	if _, err := d.DecodeView(true); err != ErrClosed {
+		t.Errorf("got error %v, want %v", err, ErrClosed)
+	}
+	if _, err := d.Decode(true); err != ErrClosed {
+		t.Errorf("got error %v, want %v", err, ErrClosed)
+	}
+	if err := d.Close(); err != nil {
+		t.Errorf("second close: %s", err)
+	}
+}
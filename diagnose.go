@@ -0,0 +1,136 @@
+package vmu
+
+import (
+	"encoding/binary"
+)
+
+// Hint is the most likely cause of a bad checksum.
+type Hint uint8
+
+const (
+	HintNone      Hint = iota // checksum is valid
+	HintByte                  // one byte of the packet has been altered
+	HintChecksum              // one byte of the checksum has been altered
+	HintTrailer               // the checksum has been truncated
+	HintTruncated             // the packet is shorter than its length
+	HintUnknown
+)
+
+func (h Hint) String() string {
+	switch h {
+	case HintNone:
+		return "none"
+	case HintByte:
+		return "byte"
+	case HintChecksum:
+		return "checksum"
+	case HintTrailer:
+		return "trailer"
+	case HintTruncated:
+		return "truncated"
+	default:
+		return "unknown"
+	}
+}
+
+// Diagnosis is the result of Diagnose. Packet holds the headers that could be
+// decoded but never the payload.
+type Diagnosis struct {
+	Packet
+	Hint Hint
+	// difference between the computed and the stored checksum
+	Delta int64
+	// number of bytes missing at the end of the packet
+	Missing int
+	// set when the headers are consistent enough to trust the channel,
+	// origin and counter of the packet
+	Attributed bool
+}
+
+// Diagnose looks at a packet that failed to decode and reports whether its
+// corruption is consistent with a single altered byte or a truncated trailer.
+// Since the checksum is a sum of bytes, a single altered byte in the packet
+// moves it by at most 255.
+func Diagnose(buffer []byte) Diagnosis {
+	var (
+		d      Diagnosis
+		offset int
+		err    error
+	)
+	d.Hint = HintUnknown
+	if len(buffer) < 4 {
+		return d
+	}
+	if w := binary.BigEndian.Uint32(buffer); w != Syncword {
+		if d.HRDPHeader, err = decodeHRDP(buffer); err != nil {
+			return d
+		}
+		offset += HRDPHeaderLen
+	}
+	if d.VMUHeader, err = decodeVMU(buffer[offset:]); err != nil {
+		return d
+	}
+	base := offset + HRDLHeaderLen
+	if len(buffer) > base+VMUHeaderLen {
+		d.DataHeader, err = decodeData(buffer[base+VMUHeaderLen:])
+		d.Attributed = err == nil && validHeaders(d.VMUHeader, d.DataHeader)
+	}
+
+	size := int(d.VMUHeader.Size)
+	if size < VMUHeaderLen || size > BufferSize {
+		return d
+	}
+	end := base + size
+	switch n := len(buffer); {
+	case n < end:
+		d.Hint, d.Missing = HintTruncated, end+HRDLTrailerLen-n
+	case n < end+HRDLTrailerLen:
+		d.Missing = end + HRDLTrailerLen - n
+		sum := make([]byte, HRDLTrailerLen)
+		binary.LittleEndian.PutUint32(sum, Sum(buffer[base:end]))
+		if string(sum[:n-end]) == string(buffer[end:]) {
+			d.Hint = HintTrailer
+		}
+	default:
+		sum := Sum(buffer[base:end])
+		d.Sum = binary.LittleEndian.Uint32(buffer[end:])
+		d.Delta = int64(sum) - int64(d.Sum)
+		switch {
+		case d.Delta == 0:
+			d.Hint = HintNone
+		case d.Delta >= -255 && d.Delta <= 255:
+			d.Hint = HintByte
+		case singleByte(sum ^ d.Sum):
+			d.Hint = HintChecksum
+		}
+	}
+	return d
+}
+
+// validHeaders reports whether the channel of v agrees with the kind of data
+// header in c and whether the length of the packet can hold both headers.
+func validHeaders(v VMUHeader, c DataHeader) bool {
+	size := VMUHeaderLen
+	switch v.Channel {
+	case VIC1, VIC2:
+		if c.Property>>4 != 2 {
+			return false
+		}
+		size += IMGHeaderLen
+	case LRSD:
+		if c.Property>>4 != 1 {
+			return false
+		}
+		size += SCCHeaderLen
+	default:
+		return false
+	}
+	return int(v.Size) >= size && v.Size <= BufferSize
+}
+
+func singleByte(x uint32) bool {
+	for x != 0 && x&0xFF == 0 {
+		x >>= 8
+	}
+	return x <= 0xFF
+}
@@ -25,7 +25,8 @@ type Dumper struct {
 	inner io.Writer
 	line  *linewriter.Writer
 
-	seen map[uint8]Packet
+	seen    map[uint8]*GapTracker
+	metrics *Metrics
 }
 
 func Dump(w io.Writer, csv bool) *Dumper {
@@ -41,15 +42,26 @@ func Dump(w io.Writer, csv bool) *Dumper {
 	return &Dumper{
 		inner: w,
 		line:  linewriter.NewWriter(4096, options...),
-		seen:  make(map[uint8]Packet),
+		seen:  make(map[uint8]*GapTracker),
 	}
 }
 
+// SetMetrics makes the Dumper record every packet it decodes in m.
+func (d *Dumper) SetMetrics(m *Metrics) {
+	d.metrics = m
+}
+
 func (d *Dumper) DumpRaw(body []byte) {
 	var offset int
+	if len(body) < 4 {
+		return
+	}
 	if w := binary.BigEndian.Uint32(body); w != Syncword {
 		offset += HRDPHeaderLen
 	}
+	if len(body) < offset+48 {
+		return
+	}
 	d.line.AppendBytes(body[offset:offset+8], 0, 0)
 	d.line.AppendBytes(body[offset+8:offset+24], 0, 0)
 	d.line.AppendBytes(body[offset+24:offset+48], 0, 0)
@@ -64,10 +76,17 @@ func (d *Dumper) Dump(body []byte, invalid, raw bool) error {
 		d.DumpRaw(body)
 	} else {
 		p, err = DecodePacket(body, false)
-		if err == nil || (err == ErrInvalid && invalid) {
-			d.dumpPacket(p, err != ErrInvalid)
-			d.seen[p.VMUHeader.Channel] = p
-
+		if err == nil || err == ErrInvalid {
+			d.metrics.Update(p, err)
+		} else {
+			d.metrics.Skip()
+		}
+		switch {
+		case err == nil:
+			d.dumpPacket(p, true, nil)
+		case err == ErrInvalid && invalid:
+			diag := Diagnose(body)
+			d.dumpPacket(p, false, &diag)
 		}
 	}
 	if err == nil || err == ErrInvalid {
@@ -76,7 +95,18 @@ func (d *Dumper) Dump(body []byte, invalid, raw bool) error {
 	return err
 }
 
-func (d *Dumper) dumpPacket(p Packet, valid bool) {
+// DumpPacket writes the line of a packet already decoded.
+func (d *Dumper) DumpPacket(p Packet, valid bool) error {
+	d.dumpPacket(p, valid, nil)
+
+	_, err := io.Copy(d.inner, d.line)
+	return err
+}
+
+// dumpPacket writes the line of p. The diagnosis of an invalid packet, when
+// given, is written at the end of the line and the fields of its data header
+// are replaced by Unknown when its headers can not be trusted.
+func (d *Dumper) dumpPacket(p Packet, valid bool, diag *Diagnosis) {
 	var bad []byte
 	if !valid {
 		bad = Invalid
@@ -85,9 +115,14 @@ func (d *Dumper) dumpPacket(p Packet, valid bool) {
 	}
 	h, v, c := p.HRDPHeader, p.VMUHeader, p.DataHeader
 
+	t, ok := d.seen[v.Channel]
+	if !ok {
+		t = new(GapTracker)
+		d.seen[v.Channel] = t
+	}
 	var diff int
-	if other, ok := d.seen[v.Channel]; ok {
-		diff = int(p.Missing(other))
+	if g, ok := t.Next(v.Sequence, v.Timestamp()); ok {
+		diff = int(g.Missing())
 	}
 
 	// d.line.AppendBytes(WhichChannel(v.Channel), 4, linewriter.AlignCenter|linewriter.Text)
@@ -100,10 +135,17 @@ func (d *Dumper) dumpPacket(p Packet, valid bool) {
 	d.line.AppendBytes(WhichMode(p.IsRealtime()), 8, linewriter.AlignCenter|linewriter.Text)
 	d.line.AppendBytes(WhichChannel(v.Channel), 4, linewriter.AlignCenter|linewriter.Text)
 	// packet HRD info
-	d.line.AppendUint(uint64(c.Origin), 2, linewriter.AlignRight|linewriter.Hex|linewriter.WithZero)
-	d.line.AppendTime(c.Acquisition(), rt.TimeFormat, linewriter.AlignCenter)
-	d.line.AppendUint(uint64(c.Counter), 8, linewriter.AlignRight)
-	d.line.AppendBytes(c.UserInfo(), 14, linewriter.AlignLeft|linewriter.Text)
+	if diag == nil || diag.Attributed {
+		d.line.AppendUint(uint64(c.Origin), 2, linewriter.AlignRight|linewriter.Hex|linewriter.WithZero)
+		d.line.AppendTime(c.Acquisition(), rt.TimeFormat, linewriter.AlignCenter)
+		d.line.AppendUint(uint64(c.Counter), 8, linewriter.AlignRight)
+		d.line.AppendBytes(c.UserInfo(), 14, linewriter.AlignLeft|linewriter.Text)
+	} else {
+		d.line.AppendBytes(Unknown, 2, linewriter.AlignRight|linewriter.Text)
+		d.line.AppendBytes(Unknown, len(rt.TimeFormat), linewriter.AlignCenter|linewriter.Text)
+		d.line.AppendBytes(Unknown, 8, linewriter.AlignRight|linewriter.Text)
+		d.line.AppendBytes(Unknown, 14, linewriter.AlignLeft|linewriter.Text)
+	}
 	d.line.AppendString(p.DataType(), 6, linewriter.AlignRight)
 	// d.line.AppendString(p.String(), 64, linewriter.AlignLeft)
 	// packet sums and validity state
@@ -112,6 +154,9 @@ func (d *Dumper) dumpPacket(p Packet, valid bool) {
 	if len(p.Data) > 0 {
 		d.line.AppendUint(xxh.Sum64(p.Data, 0), 16, linewriter.AlignRight|linewriter.Hex|linewriter.WithZero)
 	}
+	if diag != nil {
+		d.line.AppendString(diag.Hint.String(), 9, linewriter.AlignLeft|linewriter.Text)
+	}
 }
 
 func WhichChannel(c uint8) []byte {
@@ -0,0 +1,54 @@
+package vmu
+
+import (
+	"io"
+	"testing"
+)
+
+// The seed corpus of the fuzz targets is in testdata/fuzz. It holds one small
+// packet of each channel and image type, with and without a HRDP header.
+
+func FuzzDecode(f *testing.F) {
+	f.Fuzz(func(t *testing.T, data []byte) {
+		Diagnose(data)
+		for _, copy := range []bool{false, true} {
+			p, err := DecodePacket(data, copy)
+			if err != nil && err != ErrInvalid {
+				continue
+			}
+			if len(p.Data) > len(data) {
+				t.Fatalf("payload of %d bytes decoded from %d bytes", len(p.Data), len(data))
+			}
+		}
+	})
+}
+
+func FuzzDecodeHRDP(f *testing.F) {
+	f.Fuzz(func(t *testing.T, data []byte) {
+		h, err := DecodeHRDP(data)
+		if err != nil {
+			return
+		}
+		if buf := encodeHRDP(h); string(buf) != string(data[:HRDPHeaderLen]) {
+			t.Fatalf("header not encoded as decoded")
+		}
+	})
+}
+
+func FuzzDecodeVMU(f *testing.F) {
+	f.Fuzz(func(t *testing.T, data []byte) {
+		DecodeVMU(data)
+	})
+}
+
+func FuzzExportImage(f *testing.F) {
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p, err := DecodePacket(data, true)
+		if err != nil || p.VMUHeader.Channel == LRSD {
+			return
+		}
+		for _, format := range []string{"png", "jpg"} {
+			p.ExportImage(io.Discard, format)
+		}
+	})
+}
@@ -0,0 +1,172 @@
+package vmu
+
+import (
+	"time"
+)
+
+type GapKind uint8
+
+const (
+	// no packet is missing between two packets
+	GapNone GapKind = iota
+	// packets are missing between two packets
+	GapLoss
+	// the counter wrapped around, packets can be missing
+	GapWrap
+	// the counter restarted (eg: after a reboot of the payload)
+	GapReset
+	// the counter and the time went backward (eg: replayed packets)
+	GapRewind
+)
+
+func (k GapKind) String() string {
+	switch k {
+	default:
+		return "none"
+	case GapLoss:
+		return "loss"
+	case GapWrap:
+		return "wrap"
+	case GapReset:
+		return "reset"
+	case GapRewind:
+		return "rewind"
+	}
+}
+
+// WrapWindow is the maximum number of counters that can be lost when a
+// counter wraps around. A counter going backward further than that is
+// considered as a reset.
+const WrapWindow = 1 << 16
+
+type Gap struct {
+	Kind GapKind
+	// last counter seen before the gap
+	Last uint32
+	// first counter seen after the gap
+	First  uint32
+	Starts time.Time
+	Ends   time.Time
+}
+
+// Missing returns the number of packets lost in the gap. It is always zero for
+// resets and rewinds since the number of lost packets can not be known.
+func (g Gap) Missing() uint32 {
+	switch g.Kind {
+	case GapLoss, GapWrap:
+		return g.First - g.Last - 1
+	default:
+		return 0
+	}
+}
+
+func (g Gap) Duration() time.Duration {
+	return g.Ends.Sub(g.Starts)
+}
+
+// gapSlack is the number of packets a stream can be ahead of its period
+// between two packets (jitter, bursts) before a jump of the counter is no
+// longer a loss.
+const gapSlack = 16
+
+// DetectGap compares the counters of two consecutive packets and the times at
+// which they have been produced to tell a loss from a wrap, a reset or a
+// rewind of the counter.
+//
+// period is the expected time between two consecutive counters. When it is
+// known, a jump of the counter (forward for a loss, around for a wrap) is only
+// accepted if it is consistent with the time elapsed between the packets and
+// is a reset otherwise. When period is zero, forward jumps are losses and
+// backward jumps within WrapWindow are wraps.
+func DetectGap(last, first uint32, starts, ends time.Time, period time.Duration) Gap {
+	g := Gap{
+		Last:   last,
+		First:  first,
+		Starts: starts,
+		Ends:   ends,
+	}
+	diff := first - last
+	switch {
+	case diff == 1 || diff == 0:
+		g.Kind = GapNone
+	case first < last && ends.Before(starts):
+		g.Kind = GapRewind
+	case period > 0:
+		var produced int64
+		if elapsed := ends.Sub(starts); elapsed > 0 {
+			produced = int64(elapsed / period)
+		}
+		if int64(diff)-1 > 2*produced+gapSlack {
+			g.Kind = GapReset
+		} else if first > last {
+			g.Kind = GapLoss
+		} else {
+			g.Kind = GapWrap
+		}
+	case first > last:
+		g.Kind = GapLoss
+	case diff <= WrapWindow:
+		g.Kind = GapWrap
+	default:
+		g.Kind = GapReset
+	}
+	return g
+}
+
+// GapTracker detects the gaps of a stream of counters. It estimates the
+// period of the stream from consecutive counters to give DetectGap the
+// elapsed time expected between them.
+type GapTracker struct {
+	// expected time between two consecutive counters, estimated from the
+	// stream when zero.
+	Period time.Duration
+
+	estimate time.Duration
+	last     uint32
+	when     time.Time
+	seen     bool
+}
+
+// Next returns the gap between the previous counter of the stream and
+// counter, produced at when. It returns false for the first counter.
+func (t *GapTracker) Next(counter uint32, when time.Time) (Gap, bool) {
+	if !t.seen {
+		t.last, t.when, t.seen = counter, when, true
+		return Gap{}, false
+	}
+	period := t.Period
+	if period <= 0 {
+		period = t.estimate
+	}
+	g := DetectGap(t.last, counter, t.when, when, period)
+	switch elapsed := when.Sub(t.when); {
+	case g.Kind == GapReset || g.Kind == GapRewind || elapsed <= 0:
+	case g.Kind == GapNone && counter != t.last:
+		t.observe(elapsed)
+	case g.Kind == GapLoss:
+		t.observe(elapsed / time.Duration(counter-t.last))
+	}
+	t.last, t.when = counter, when
+	return g, true
+}
+
+// observe updates the estimated period with a moving average.
+func (t *GapTracker) observe(d time.Duration) {
+	if t.estimate == 0 {
+		t.estimate = d
+		return
+	}
+	t.estimate = (7*t.estimate + d) / 8
+}
+
+// SequenceGap detects the gap between the VMU sequence of prev and p without
+// knowing the period of the stream. Use a GapTracker for a stream of packets.
+func SequenceGap(p, prev Packet) Gap {
+	return DetectGap(prev.VMUHeader.Sequence, p.VMUHeader.Sequence, prev.VMUHeader.Timestamp(), p.VMUHeader.Timestamp(), 0)
+}
+
+// CounterGap detects the gap between the origin counter of prev and p without
+// knowing the period of the stream. Use a GapTracker for a stream of packets.
+func CounterGap(p, prev Packet) Gap {
+	return DetectGap(prev.DataHeader.Counter, p.DataHeader.Counter, prev.DataHeader.Acquisition(), p.DataHeader.Acquisition(), 0)
+}
@@ -0,0 +1,80 @@
+package vmu
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDetectGap(t *testing.T) {
+	var (
+		when   = time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+		period = 100 * time.Millisecond
+	)
+	data := []struct {
+		Name    string
+		Last    uint32
+		First   uint32
+		Elapsed time.Duration
+		Period  time.Duration
+		Kind    GapKind
+		Missing uint32
+	}{
+		{Name: "next", Last: 10, First: 11, Elapsed: period, Period: period, Kind: GapNone},
+		{Name: "duplicate", Last: 10, First: 10, Elapsed: 0, Period: period, Kind: GapNone},
+		{Name: "loss", Last: 10, First: 21, Elapsed: 11 * period, Period: period, Kind: GapLoss, Missing: 10},
+		{Name: "loss-with-jitter", Last: 10, First: 31, Elapsed: 15 * period, Period: period, Kind: GapLoss, Missing: 20},
+		{Name: "wrap", Last: 1<<32 - 3, First: 2, Elapsed: 5 * period, Period: period, Kind: GapWrap, Missing: 4},
+		{Name: "reset-to-zero", Last: 5000, First: 0, Elapsed: 2 * time.Second, Period: period, Kind: GapReset},
+		{Name: "reset-higher", Last: 10, First: 1000000, Elapsed: time.Second, Period: period, Kind: GapReset},
+		{Name: "long-loss", Last: 10, First: 36011, Elapsed: time.Hour, Period: period, Kind: GapLoss, Missing: 36000},
+		{Name: "rewind", Last: 5000, First: 100, Elapsed: -time.Minute, Period: period, Kind: GapRewind},
+		{Name: "unknown-period-loss", Last: 10, First: 1000000, Elapsed: time.Second, Kind: GapLoss, Missing: 999989},
+		{Name: "unknown-period-wrap", Last: 1<<32 - 3, First: 2, Elapsed: time.Second, Kind: GapWrap, Missing: 4},
+		{Name: "unknown-period-reset", Last: 1 << 20, First: 0, Elapsed: time.Second, Kind: GapReset},
+	}
+	for _, d := range data {
+		g := DetectGap(d.Last, d.First, when, when.Add(d.Elapsed), d.Period)
+		if g.Kind != d.Kind {
+			t.Errorf("%s: got %s, want %s", d.Name, g.Kind, d.Kind)
+			continue
+		}
+		if m := g.Missing(); m != d.Missing {
+			t.Errorf("%s: got %d missing, want %d", d.Name, m, d.Missing)
+		}
+	}
+}
+
+func TestGapTracker(t *testing.T) {
+	var (
+		tracker GapTracker
+		when    = time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+		period  = 100 * time.Millisecond
+		counter = uint32(1<<32 - 50)
+	)
+	next := func(step uint32, elapsed time.Duration) Gap {
+		counter += step
+		when = when.Add(elapsed)
+		g, _ := tracker.Next(counter, when)
+		return g
+	}
+	if _, ok := tracker.Next(counter, when); ok {
+		t.Fatalf("gap found for the first counter")
+	}
+	for i := 0; i < 20; i++ {
+		if g := next(1, period); g.Kind != GapNone {
+			t.Fatalf("packet %d: unexpected %s", i, g.Kind)
+		}
+	}
+	if g := next(5, 5*period); g.Kind != GapLoss || g.Missing() != 4 {
+		t.Errorf("loss: got %s (%d missing)", g.Kind, g.Missing())
+	}
+	if g := next(40, 40*period); g.Kind != GapWrap || g.Missing() != 39 {
+		t.Errorf("wrap: got %s (%d missing)", g.Kind, g.Missing())
+	}
+	if g := next(1<<24, 2*period); g.Kind != GapReset || g.Missing() != 0 {
+		t.Errorf("reset: got %s (%d missing)", g.Kind, g.Missing())
+	}
+	if g := next(1, period); g.Kind != GapNone {
+		t.Errorf("after reset: got %s", g.Kind)
+	}
+}
@@ -1,24 +1,20 @@
 package vmu
 
 import (
-  "bytes"
-  "encoding/binary"
-  "image"
-	"image/color"
+	"encoding/binary"
+	"image"
 )
 
+// The functions below write the pixels of the images directly: their callers
+// check that points holds enough bytes for an image of x by y.
+
 func imageRGB(x, y int, points []byte) image.Image {
 	g := image.NewRGBA(image.Rect(0, 0, x, y))
-	buf := bytes.NewBuffer(points)
-	for i := 0; i < y; i++ {
-		for j := 0; j < x; j++ {
-			var red, green, blue uint8
-			binary.Read(buf, binary.BigEndian, &red)
-			binary.Read(buf, binary.BigEndian, &green)
-			binary.Read(buf, binary.BigEndian, &blue)
-
-			g.Set(j, i, color.RGBA{R: red, G: green, B: blue, A: 255})
-		}
+	for i, j := 0, 0; i < len(g.Pix); i, j = i+4, j+3 {
+		g.Pix[i] = points[j]
+		g.Pix[i+1] = points[j+1]
+		g.Pix[i+2] = points[j+2]
+		g.Pix[i+3] = 0xFF
 	}
 	return g
 }
@@ -26,19 +22,12 @@ func imageRGB(x, y int, points []byte) image.Image {
 func imageLBR(x, y int, points []byte) image.Image {
 	g := image.NewYCbCr(image.Rect(0, 0, x, y), image.YCbCrSubsampleRatio422)
 
-	ls := make([]byte, 0, len(points)/2)
-	bs := make([]byte, 0, len(points)/4)
-	rs := make([]byte, 0, len(points)/4)
-
-	for i := 0; i < len(points); i += 4 {
-		ls = append(ls, points[i], points[i+2])
-		bs = append(rs, points[i+1])
-		rs = append(rs, points[i+3])
+	var l, c int
+	for i := 0; i+3 < len(points) && l+1 < len(g.Y) && c < len(g.Cb); i += 4 {
+		g.Y[l], g.Y[l+1] = points[i], points[i+2]
+		g.Cb[c], g.Cr[c] = points[i+1], points[i+3]
+		l, c = l+2, c+1
 	}
-	copy(g.Y, ls)
-	copy(g.Cb, bs)
-	copy(g.Cr, rs)
-
 	return g
 }
 
@@ -57,24 +46,18 @@ func imageI420(x, y int, points []byte) image.Image {
 
 func imageGray8(x, y int, points []byte) image.Image {
 	g := image.NewGray(image.Rect(0, 0, x, y))
-	buf := bytes.NewBuffer(points)
-	for i := 0; i < y; i++ {
-		for j, c := range buf.Next(x) {
-			g.Set(j, i, color.Gray{Y: uint8(c)})
-		}
-	}
+	copy(g.Pix, points)
 	return g
 }
 
 func imageGray16(x, y int, points []byte, order binary.ByteOrder) image.Image {
 	g := image.NewGray16(image.Rect(0, 0, x, y))
-	buf := bytes.NewBuffer(points)
-	for i := 0; i < y; i++ {
-		for j := 0; j < x; j++ {
-			var c uint16
-			binary.Read(buf, order, &c)
-			g.Set(j, i, color.Gray16{Y: c})
-		}
+	if order == binary.BigEndian {
+		copy(g.Pix, points)
+		return g
+	}
+	for i := 0; i+1 < len(g.Pix); i += 2 {
+		g.Pix[i], g.Pix[i+1] = points[i+1], points[i]
 	}
 	return g
 }
@@ -0,0 +1,359 @@
+package vmu
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/busoc/rt"
+)
+
+// IndexExt is the extension added to the name of an archive to get the name
+// of its index.
+const IndexExt = ".idx"
+
+const (
+	indexEntryLen  = 80
+	indexHeaderLen = 16
+)
+
+var (
+	indexMagic = []byte("VMUIDX03")
+
+	ErrIndex = errors.New("invalid index")
+	// ErrStaleIndex is returned by LoadIndex when the archive has changed
+	// since its index has been built.
+	ErrStaleIndex = errors.New("stale index")
+)
+
+// IndexEntry describes the position and the headers of a packet in an
+// archive.
+type IndexEntry struct {
+	Offset   int64
+	Length   uint32
+	Channel  uint8
+	Origin   uint8
+	Type     ImageType
+	Valid    bool
+	Sequence uint32
+	Counter  uint32
+	AcqTime  time.Duration
+	UPI      [UPILen]byte
+	Property uint8
+	// origin, size and time of the VMU header
+	VMUOrigin uint8
+	Size      uint32
+	Coarse    uint32
+	Fine      uint16
+}
+
+// Header returns a packet holding the headers recorded in e, without payload.
+func (e IndexEntry) Header() Packet {
+	var p Packet
+	p.VMUHeader = VMUHeader{
+		Size:     e.Size,
+		Channel:  e.Channel,
+		Origin:   e.VMUOrigin,
+		Sequence: e.Sequence,
+		Coarse:   e.Coarse,
+		Fine:     e.Fine,
+	}
+	p.DataHeader = DataHeader{
+		Property: e.Property,
+		Origin:   e.Origin,
+		Type:     e.Type,
+		Counter:  e.Counter,
+		AcqTime:  e.AcqTime,
+		UPI:      e.UPI,
+	}
+	return p
+}
+
+func (e IndexEntry) Acquisition() time.Time {
+	return DataHeader{AcqTime: e.AcqTime}.Acquisition()
+}
+
+// UserInfo returns the UPI of the packet like DataHeader.UserInfo.
+func (e IndexEntry) UserInfo() []byte {
+	return userInfoOrKind(e.UPI, e.Property)
+}
+
+// Query selects entries of an index. Zero fields match every entry.
+type Query struct {
+	Channel uint8
+	Origin  uint8
+	Starts  time.Time
+	Ends    time.Time
+	// range of origin counters, nil when unbounded
+	First *uint32
+	Last  *uint32
+	UPI   string
+}
+
+func (q Query) IsZero() bool {
+	return q == Query{}
+}
+
+func (q Query) Match(e IndexEntry) bool {
+	return q.match(e.Channel, e.Origin, e.Counter, e.Acquisition(), e.UserInfo())
+}
+
+func (q Query) Keep(p Packet) bool {
+	return q.match(p.VMUHeader.Channel, p.DataHeader.Origin, p.DataHeader.Counter, p.DataHeader.Acquisition(), p.DataHeader.UserInfo())
+}
+
+func (q Query) match(channel, origin uint8, counter uint32, acq time.Time, upi []byte) bool {
+	if q.Channel > 0 && q.Channel != channel {
+		return false
+	}
+	if q.Origin > 0 && q.Origin != origin {
+		return false
+	}
+	if !q.Starts.IsZero() && acq.Before(q.Starts) {
+		return false
+	}
+	if !q.Ends.IsZero() && acq.After(q.Ends) {
+		return false
+	}
+	if q.First != nil && counter < *q.First {
+		return false
+	}
+	if q.Last != nil && counter > *q.Last {
+		return false
+	}
+	if q.UPI != "" && q.UPI != string(upi) {
+		return false
+	}
+	return true
+}
+
+// Index gives the position of every packet of an archive file. Size and
+// ModTime are those of the archive when the index has been built.
+type Index struct {
+	File    string
+	Size    int64
+	ModTime time.Time
+	Entries []IndexEntry
+}
+
+// BuildIndex scans file, with or without HRDP headers, and returns its index.
+func BuildIndex(file string) (*Index, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// the archive is described as it is before being scanned: an index built
+	// while the archive grows is seen as stale.
+	i, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	x := Index{
+		File:    file,
+		Size:    i.Size(),
+		ModTime: i.ModTime(),
+	}
+	var (
+		r      = bufio.NewReader(f)
+		next   func([]byte) (int, error)
+		offset int64
+	)
+	if word, err := r.Peek(4); err != nil {
+		if err == io.EOF {
+			return &x, nil
+		}
+		return nil, err
+	} else if binary.BigEndian.Uint32(word) == Syncword {
+		hr := NewHRDLReader(r)
+		next = func(bs []byte) (int, error) {
+			n, err := hr.Read(bs)
+			offset = hr.Offset() - int64(n)
+			return n, err
+		}
+	} else {
+		rs := rt.NewReader(r)
+		var pos int64
+		next = func(bs []byte) (int, error) {
+			n, err := rs.Read(bs)
+			offset, pos = pos, pos+int64(n)
+			return n, err
+		}
+	}
+
+	buf := AcquireBuffer()
+	defer ReleaseBuffer(buf)
+
+	buffer := *buf
+	for {
+		n, err := next(buffer)
+		switch err {
+		case nil:
+		case ErrSkip:
+			continue
+		case io.EOF:
+			return &x, nil
+		default:
+			return nil, err
+		}
+		p, err := decodePacket(buffer[:n], false)
+		if err != nil && err != ErrInvalid {
+			continue
+		}
+		x.Entries = append(x.Entries, IndexEntry{
+			Offset:    offset,
+			Length:    uint32(n),
+			Channel:   p.VMUHeader.Channel,
+			Origin:    p.DataHeader.Origin,
+			Type:      p.DataHeader.Type,
+			Valid:     err == nil,
+			Sequence:  p.VMUHeader.Sequence,
+			Counter:   p.DataHeader.Counter,
+			AcqTime:   p.DataHeader.AcqTime,
+			UPI:       p.DataHeader.UPI,
+			Property:  p.DataHeader.Property,
+			VMUOrigin: p.VMUHeader.Origin,
+			Size:      p.VMUHeader.Size,
+			Coarse:    p.VMUHeader.Coarse,
+			Fine:      p.VMUHeader.Fine,
+		})
+	}
+}
+
+// LoadIndex reads the index of file written by Index.Save. It returns
+// ErrStaleIndex when the size or the modification time of file differ from
+// those recorded in the index.
+func LoadIndex(file string) (*Index, error) {
+	i, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	bs, err := os.ReadFile(file + IndexExt)
+	if err != nil {
+		return nil, err
+	}
+	if len(bs) < len(indexMagic)+indexHeaderLen || !bytes.Equal(bs[:len(indexMagic)], indexMagic) {
+		return nil, ErrIndex
+	}
+	bs = bs[len(indexMagic):]
+	x := Index{
+		File:    file,
+		Size:    int64(binary.LittleEndian.Uint64(bs)),
+		ModTime: time.Unix(0, int64(binary.LittleEndian.Uint64(bs[8:]))),
+	}
+	if x.Size != i.Size() || !x.ModTime.Equal(i.ModTime()) {
+		return nil, ErrStaleIndex
+	}
+	bs = bs[indexHeaderLen:]
+	if len(bs)%indexEntryLen != 0 {
+		return nil, ErrIndex
+	}
+	x.Entries = make([]IndexEntry, 0, len(bs)/indexEntryLen)
+	for i := 0; i < len(bs); i += indexEntryLen {
+		x.Entries = append(x.Entries, decodeIndexEntry(bs[i:i+indexEntryLen]))
+	}
+	return &x, nil
+}
+
+// Save writes the index next to its archive.
+func (x *Index) Save() error {
+	w, err := os.Create(x.File + IndexExt)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	if _, err := x.WriteTo(w); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+func (x *Index) WriteTo(w io.Writer) (int64, error) {
+	buf := make([]byte, len(indexMagic)+indexHeaderLen, len(indexMagic)+indexHeaderLen+len(x.Entries)*indexEntryLen)
+	copy(buf, indexMagic)
+	binary.LittleEndian.PutUint64(buf[len(indexMagic):], uint64(x.Size))
+	binary.LittleEndian.PutUint64(buf[len(indexMagic)+8:], uint64(x.ModTime.UnixNano()))
+	for _, e := range x.Entries {
+		buf = append(buf, encodeIndexEntry(e)...)
+	}
+	n, err := w.Write(buf)
+	return int64(n), err
+}
+
+// Any reports whether at least one entry of the index matches q.
+func (x *Index) Any(q Query) bool {
+	for _, e := range x.Entries {
+		if q.Match(e) {
+			return true
+		}
+	}
+	return false
+}
+
+// Find returns the entries of the index matching q.
+func (x *Index) Find(q Query) []IndexEntry {
+	var es []IndexEntry
+	for _, e := range x.Entries {
+		if q.Match(e) {
+			es = append(es, e)
+		}
+	}
+	return es
+}
+
+// Packet reads and decodes the packet described by e from the archive r.
+func (x *Index) Packet(r io.ReaderAt, e IndexEntry, data bool) (Packet, error) {
+	buf := make([]byte, e.Length)
+	if _, err := r.ReadAt(buf, e.Offset); err != nil {
+		return Packet{}, err
+	}
+	return decodePacket(buf, data)
+}
+
+func encodeIndexEntry(e IndexEntry) []byte {
+	buf := make([]byte, indexEntryLen)
+	binary.LittleEndian.PutUint64(buf, uint64(e.Offset))
+	binary.LittleEndian.PutUint32(buf[8:], e.Length)
+	buf[12] = e.Channel
+	buf[13] = e.Origin
+	buf[14] = byte(e.Type)
+	if e.Valid {
+		buf[15] = 1
+	}
+	binary.LittleEndian.PutUint32(buf[16:], e.Sequence)
+	binary.LittleEndian.PutUint32(buf[20:], e.Counter)
+	binary.LittleEndian.PutUint64(buf[24:], uint64(e.AcqTime))
+	copy(buf[32:], e.UPI[:])
+	buf[64] = e.Property
+	buf[65] = e.VMUOrigin
+	binary.LittleEndian.PutUint16(buf[66:], e.Fine)
+	binary.LittleEndian.PutUint32(buf[68:], e.Coarse)
+	binary.LittleEndian.PutUint32(buf[72:], e.Size)
+	return buf
+}
+
+func decodeIndexEntry(buf []byte) IndexEntry {
+	var e IndexEntry
+	e.Offset = int64(binary.LittleEndian.Uint64(buf))
+	e.Length = binary.LittleEndian.Uint32(buf[8:])
+	e.Channel = buf[12]
+	e.Origin = buf[13]
+	e.Type = ImageType(buf[14])
+	e.Valid = buf[15] == 1
+	e.Sequence = binary.LittleEndian.Uint32(buf[16:])
+	e.Counter = binary.LittleEndian.Uint32(buf[20:])
+	e.AcqTime = time.Duration(binary.LittleEndian.Uint64(buf[24:]))
+	copy(e.UPI[:], buf[32:])
+	e.Property = buf[64]
+	e.VMUOrigin = buf[65]
+	e.Fine = binary.LittleEndian.Uint16(buf[66:])
+	e.Coarse = binary.LittleEndian.Uint32(buf[68:])
+	e.Size = binary.LittleEndian.Uint32(buf[72:])
+	return e
+}
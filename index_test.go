@@ -0,0 +1,131 @@
+package vmu_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/busoc/vmu"
+	"github.com/busoc/vmu/vmutest"
+)
+
+func TestIndexQueryCounterZero(t *testing.T) {
+	cfg := vmutest.Default()
+	cfg.Count = 30
+	x := buildIndex(t, cfg)
+
+	zero := uint32(0)
+	es := x.Find(vmu.Query{First: &zero, Last: &zero})
+	if len(es) != len(cfg.Channels) {
+		t.Fatalf("%d packets found with counter 0, want %d", len(es), len(cfg.Channels))
+	}
+	for _, e := range es {
+		if e.Counter != 0 {
+			t.Errorf("packet with counter %d found", e.Counter)
+		}
+	}
+}
+
+func TestIndexUserInfo(t *testing.T) {
+	cfg := vmutest.Default()
+	cfg.Count = 30
+	x := buildIndex(t, cfg)
+
+	for _, e := range x.Entries {
+		want := "IMAGE"
+		if e.Channel == vmu.LRSD {
+			want = cfg.UPIs[0]
+		}
+		if got := string(e.UserInfo()); got != want {
+			t.Errorf("channel %d: got upi %q, want %q", e.Channel, got, want)
+		}
+	}
+}
+
+func TestIndexPlayback(t *testing.T) {
+	cfg := vmutest.Default()
+	cfg.Count, cfg.Gaps, cfg.Playback = 200, 0.1, 0.5
+	x := buildIndex(t, cfg)
+	if err := x.Save(); err != nil {
+		t.Fatal(err)
+	}
+	x, err := vmu.LoadIndex(x.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		g        = vmutest.New(cfg)
+		playback int
+	)
+	for i := 0; ; i++ {
+		p, _, err := g.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i >= len(x.Entries) {
+			t.Fatalf("%d entries indexed, want more", len(x.Entries))
+		}
+		if !p.IsRealtime() {
+			playback++
+		}
+		if got, want := x.Entries[i].Header().IsRealtime(), p.IsRealtime(); got != want {
+			t.Errorf("entry %d: realtime %t, want %t", i, got, want)
+		}
+	}
+	if playback == 0 {
+		t.Fatalf("no playback packets generated")
+	}
+}
+
+func TestLoadIndexStale(t *testing.T) {
+	cfg := vmutest.Default()
+	cfg.Count = 30
+	x := buildIndex(t, cfg)
+	if err := x.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vmu.LoadIndex(x.File); err != nil {
+		t.Fatalf("loading fresh index: %s", err)
+	}
+
+	f, err := os.OpenFile(x.File, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Count = 1
+	if _, err := vmutest.New(cfg).WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if _, err := vmu.LoadIndex(x.File); err != vmu.ErrStaleIndex {
+		t.Fatalf("loading index of modified archive: got %v, want %s", err, vmu.ErrStaleIndex)
+	}
+}
+
+func buildIndex(t *testing.T, cfg vmutest.Config) *vmu.Index {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "rt_00_04.dat")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vmutest.New(cfg).WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	x, err := vmu.BuildIndex(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(x.Entries) != cfg.Count {
+		t.Fatalf("%d packets indexed, want %d", len(x.Entries), cfg.Count)
+	}
+	return x
+}
@@ -0,0 +1,128 @@
+package vmu
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// Listen returns a reader of the HRDL packets received on addr. With udp,
+// each datagram should contain exactly one packet. With tcp, packets are read
+// from every accepted connection as a stream of frames delimited by Syncword.
+// Each call to Read on the returned reader gives one packet, so that it can be
+// used directly with NewDecoder.
+func Listen(network, addr string) (io.ReadCloser, error) {
+	switch network {
+	case "udp", "udp4", "udp6":
+		a, err := net.ResolveUDPAddr(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return net.ListenUDP(network, a)
+	case "tcp", "tcp4", "tcp6":
+		l, err := net.Listen(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		s := &streamReader{
+			listener: l,
+			frames:   make(chan []byte, 64),
+			done:     make(chan struct{}),
+			conns:    make(map[net.Conn]struct{}),
+		}
+		go s.accept()
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unsupported network %s", network)
+	}
+}
+
+type streamReader struct {
+	listener net.Listener
+	frames   chan []byte
+	done     chan struct{}
+	once     sync.Once
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func (s *streamReader) Read(bs []byte) (int, error) {
+	frame, ok := <-s.frames
+	if !ok {
+		return 0, io.EOF
+	}
+	if len(frame) > len(bs) {
+		return 0, io.ErrShortBuffer
+	}
+	return copy(bs, frame), nil
+}
+
+func (s *streamReader) Close() error {
+	var err error
+	s.once.Do(func() {
+		close(s.done)
+		err = s.listener.Close()
+
+		s.mu.Lock()
+		for c := range s.conns {
+			c.Close()
+		}
+		s.mu.Unlock()
+	})
+	return err
+}
+
+func (s *streamReader) accept() {
+	var wg sync.WaitGroup
+	defer func() {
+		wg.Wait()
+		close(s.frames)
+	}()
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+
+		wg.Add(1)
+		go func(c net.Conn) {
+			defer func() {
+				s.mu.Lock()
+				delete(s.conns, c)
+				s.mu.Unlock()
+				c.Close()
+				wg.Done()
+			}()
+			s.handle(c)
+		}(c)
+	}
+}
+
+func (s *streamReader) handle(c net.Conn) {
+	var (
+		r      = NewHRDLReader(c)
+		buffer = AcquireBuffer()
+	)
+	defer ReleaseBuffer(buffer)
+	for {
+		n, err := r.Read(*buffer)
+		if err == ErrSkip {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		frame := make([]byte, n)
+		copy(frame, (*buffer)[:n])
+		select {
+		case s.frames <- frame:
+		case <-s.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package vmu
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds, in seconds, of the latency histograms.
+var LatencyBuckets = []float64{0.001, 0.01, 0.1, 0.5, 1, 5, 10, 60, 300, 3600}
+
+// Metrics collects statistics on decoded packets by channel and origin and
+// exposes them in the Prometheus text format. A nil *Metrics can be used and
+// collects nothing.
+type Metrics struct {
+	mu      sync.Mutex
+	series  map[series]*counters
+	skipped uint64
+}
+
+type series struct {
+	Channel uint8
+	Origin  uint8
+}
+
+type counters struct {
+	Packets uint64
+	Bytes   uint64
+	Invalid uint64
+	Missing uint64
+	Gaps    [GapRewind + 1]uint64
+
+	Delay   histogram
+	Archive histogram
+
+	gaps GapTracker
+}
+
+type histogram struct {
+	Buckets []uint64
+	Count   uint64
+	Sum     float64
+}
+
+func (h *histogram) Observe(d time.Duration) {
+	if h.Buckets == nil {
+		h.Buckets = make([]uint64, len(LatencyBuckets))
+	}
+	v := d.Seconds()
+	for i, b := range LatencyBuckets {
+		if v <= b {
+			h.Buckets[i]++
+		}
+	}
+	h.Count++
+	h.Sum += v
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{series: make(map[series]*counters)}
+}
+
+// Update records a packet decoded with err (nil or ErrInvalid). Its size is
+// the one given by its VMU header.
+func (m *Metrics) Update(p Packet, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := series{Channel: p.VMUHeader.Channel, Origin: p.DataHeader.Origin}
+	c, ok := m.series[k]
+	if !ok {
+		c = &counters{}
+		m.series[k] = c
+	}
+	c.Packets++
+	c.Bytes += uint64(p.VMUHeader.Size)
+	if err == ErrInvalid {
+		c.Invalid++
+	}
+	if g, ok := c.gaps.Next(p.DataHeader.Counter, p.DataHeader.Acquisition()); ok {
+		c.Gaps[g.Kind]++
+		c.Missing += uint64(g.Missing())
+	}
+
+	c.Delay.Observe(p.VMUHeader.Timestamp().Sub(p.DataHeader.Acquisition()))
+	if p.HRDPHeader.Size > 0 {
+		c.Archive.Observe(p.HRDPHeader.Elapsed())
+	}
+}
+
+// Skip records a packet that could not be decoded.
+func (m *Metrics) Skip() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.skipped++
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "text/plain; version=0.0.4")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	if m == nil {
+		return 0, nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]series, 0, len(m.series))
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Channel != keys[j].Channel {
+			return keys[i].Channel < keys[j].Channel
+		}
+		return keys[i].Origin < keys[j].Origin
+	})
+
+	bw := bufio.NewWriter(w)
+	ws := counting{Writer: bw}
+	counter := func(name, help string, get func(*counters) uint64) {
+		fmt.Fprintf(&ws, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, k := range keys {
+			fmt.Fprintf(&ws, "%s{%s} %d\n", name, k.labels(), get(m.series[k]))
+		}
+	}
+	counter("vmu_packets_total", "Number of packets decoded.", func(c *counters) uint64 { return c.Packets })
+	counter("vmu_bytes_total", "Number of bytes decoded.", func(c *counters) uint64 { return c.Bytes })
+	counter("vmu_invalid_total", "Number of packets with an invalid checksum.", func(c *counters) uint64 { return c.Invalid })
+	counter("vmu_missing_total", "Number of packets missing according to origin counters.", func(c *counters) uint64 { return c.Missing })
+
+	fmt.Fprintf(&ws, "# HELP vmu_gaps_total Number of gaps in origin counters by kind.\n# TYPE vmu_gaps_total counter\n")
+	for _, k := range keys {
+		c := m.series[k]
+		for i := GapLoss; i <= GapRewind; i++ {
+			fmt.Fprintf(&ws, "vmu_gaps_total{%s,kind=%q} %d\n", k.labels(), i.String(), c.Gaps[i])
+		}
+	}
+
+	fmt.Fprintf(&ws, "# HELP vmu_skipped_total Number of packets that could not be decoded.\n# TYPE vmu_skipped_total counter\nvmu_skipped_total %d\n", m.skipped)
+
+	histo := func(name, help string, get func(*counters) histogram) {
+		fmt.Fprintf(&ws, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+		for _, k := range keys {
+			h, ls := get(m.series[k]), k.labels()
+			for i, b := range LatencyBuckets {
+				var n uint64
+				if h.Buckets != nil {
+					n = h.Buckets[i]
+				}
+				fmt.Fprintf(&ws, "%s_bucket{%s,le=\"%s\"} %d\n", name, ls, strconv.FormatFloat(b, 'g', -1, 64), n)
+			}
+			fmt.Fprintf(&ws, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, ls, h.Count)
+			fmt.Fprintf(&ws, "%s_sum{%s} %g\n%s_count{%s} %d\n", name, ls, h.Sum, name, ls, h.Count)
+		}
+	}
+	histo("vmu_latency_seconds", "Delay between acquisition and VMU times.", func(c *counters) histogram { return c.Delay })
+	histo("vmu_archive_latency_seconds", "Delay between acquisition and HRDP archive times.", func(c *counters) histogram { return c.Archive })
+
+	if err := bw.Flush(); err != nil {
+		return ws.n, err
+	}
+	return ws.n, ws.err
+}
+
+func (s series) labels() string {
+	return fmt.Sprintf("channel=%q,origin=\"%02x\"", WhichChannel(s.Channel), s.Origin)
+}
+
+type counting struct {
+	io.Writer
+	n   int64
+	err error
+}
+
+func (c *counting) Write(bs []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.Writer.Write(bs)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
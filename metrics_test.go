@@ -0,0 +1,63 @@
+package vmu_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/busoc/rt"
+	"github.com/busoc/vmu"
+	"github.com/busoc/vmu/vmutest"
+)
+
+func TestMetricsDumpAndDecodeAgree(t *testing.T) {
+	cfg := vmutest.Default()
+	cfg.Count, cfg.Invalid = 50, 0.1
+
+	var buf bytes.Buffer
+	if _, err := vmutest.New(cfg).WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	dumped := vmu.NewMetrics()
+	d := vmu.Dump(io.Discard, false)
+	d.SetMetrics(dumped)
+	r := rt.NewReader(bytes.NewReader(buf.Bytes()))
+	body := make([]byte, vmu.BufferSize)
+	for {
+		n, err := r.Read(body)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.Dump(body[:n], true, false)
+	}
+
+	decoded := vmu.NewMetrics()
+	dec := vmu.NewDecoder(rt.NewReader(bytes.NewReader(buf.Bytes())), nil)
+	defer dec.Close()
+	for {
+		p, err := dec.Decode(false)
+		if err == io.EOF {
+			break
+		}
+		switch err {
+		case nil, vmu.ErrInvalid:
+			decoded.Update(p, err)
+		case vmu.ErrSkip:
+			decoded.Skip()
+		default:
+			t.Fatal(err)
+		}
+	}
+
+	var want, got strings.Builder
+	decoded.WriteTo(&want)
+	dumped.WriteTo(&got)
+	if got.String() != want.String() {
+		t.Errorf("metrics differ\ngot:\n%s\nwant:\n%s", got.String(), want.String())
+	}
+}
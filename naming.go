@@ -0,0 +1,171 @@
+package vmu
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultNaming is the template used by Packet.Filename. It gives the names
+// packets have always had.
+const DefaultNaming = "{origin:%04x}_{upi}_{channel}_{counter:%06d}_{acq:" + nameTimeFormat + "}_{delta:%09d}.{ext}"
+
+// Naming is a template of file names. Placeholders are written {name} or
+// {name:format} where format is a Go time layout for times and a fmt verb for
+// other values, eg {upi}/{acq:2006/002}/{counter}.{ext}. A template can
+// contain directories.
+//
+// The placeholders of a packet are:
+//
+//	origin, channel, chan (its name), mode (realtime or playback), upi,
+//	stream, counter, sequence, size, property, type (data type), ext (dat),
+//	delta (float minutes between acquisition and VMU times), acq, aux, vmu
+//	and archive (times).
+type Naming struct {
+	parts []namePart
+}
+
+type namePart struct {
+	Literal string
+	Name    string
+	Format  string
+}
+
+var packetNames = map[string]struct{}{
+	"origin": {}, "channel": {}, "chan": {}, "mode": {}, "upi": {},
+	"stream": {}, "counter": {}, "sequence": {}, "size": {}, "property": {},
+	"type": {}, "ext": {}, "delta": {}, "acq": {}, "aux": {}, "vmu": {},
+	"archive": {},
+}
+
+// ParseNaming parses tpl. Placeholders other than the ones of packets are
+// accepted when listed in extra.
+func ParseNaming(tpl string, extra ...string) (*Naming, error) {
+	var n Naming
+	for len(tpl) > 0 {
+		ix := strings.IndexByte(tpl, '{')
+		if ix < 0 {
+			n.parts = append(n.parts, namePart{Literal: tpl})
+			break
+		}
+		if ix > 0 {
+			n.parts = append(n.parts, namePart{Literal: tpl[:ix]})
+		}
+		tpl = tpl[ix+1:]
+		ix = strings.IndexByte(tpl, '}')
+		if ix < 0 {
+			return nil, fmt.Errorf("naming: unterminated placeholder")
+		}
+		var p namePart
+		p.Name, p.Format = tpl[:ix], ""
+		if i := strings.IndexByte(p.Name, ':'); i >= 0 {
+			p.Name, p.Format = p.Name[:i], p.Name[i+1:]
+		}
+		if _, ok := packetNames[p.Name]; !ok && !contains(extra, p.Name) {
+			return nil, fmt.Errorf("naming: unknown placeholder %s", p.Name)
+		}
+		n.parts = append(n.parts, p)
+		tpl = tpl[ix+1:]
+	}
+	return &n, nil
+}
+
+// Format returns the name of p. Values in extra are used for the placeholders
+// they define, including the ones of p.
+func (n *Naming) Format(p Packet, extra map[string]interface{}) string {
+	var b strings.Builder
+	for _, part := range n.parts {
+		if part.Name == "" {
+			b.WriteString(part.Literal)
+			continue
+		}
+		v, ok := extra[part.Name]
+		if !ok {
+			v = packetValue(p, part.Name)
+		}
+		b.WriteString(formatPart(v, part.Format))
+	}
+	return b.String()
+}
+
+func packetValue(p Packet, name string) interface{} {
+	switch name {
+	case "origin":
+		return p.DataHeader.Origin
+	case "channel":
+		return p.VMUHeader.Channel
+	case "chan":
+		return string(WhichChannel(p.VMUHeader.Channel))
+	case "mode":
+		return string(WhichMode(p.IsRealtime()))
+	case "upi":
+		return string(p.DataHeader.UserInfo())
+	case "stream":
+		return p.DataHeader.Stream
+	case "counter":
+		return p.DataHeader.Counter
+	case "sequence":
+		return p.VMUHeader.Sequence
+	case "size":
+		return p.VMUHeader.Size
+	case "property":
+		return p.DataHeader.Property
+	case "type":
+		return p.DataType()
+	case "ext":
+		return datExt
+	case "delta":
+		return p.VMUHeader.Timestamp().Sub(p.DataHeader.Acquisition()).Minutes()
+	case "acq":
+		return p.DataHeader.Acquisition()
+	case "aux":
+		return p.DataHeader.Auxiliary()
+	case "vmu":
+		return p.VMUHeader.Timestamp()
+	case "archive":
+		return p.HRDPHeader.Archive()
+	default:
+		return ""
+	}
+}
+
+func formatPart(v interface{}, format string) string {
+	if t, ok := v.(time.Time); ok {
+		if format == "" {
+			format = nameTimeFormat
+		}
+		return t.Format(format)
+	}
+	if format == "" {
+		format = "%v"
+	}
+	return fmt.Sprintf(format, v)
+}
+
+func contains(vs []string, v string) bool {
+	for _, s := range vs {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+var defaultNaming *Naming
+
+func init() {
+	n, err := ParseNaming(DefaultNaming)
+	if err != nil {
+		panic(err)
+	}
+	defaultNaming = n
+}
+
+// Filename returns the name of p with ext as the {ext} placeholder, dat when
+// ext is empty.
+func (n *Naming) Filename(p Packet, ext string) string {
+	if ext == "" {
+		return n.Format(p, nil)
+	}
+	return n.Format(p, map[string]interface{}{"ext": ext})
+}
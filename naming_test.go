@@ -0,0 +1,32 @@
+package vmu
+
+import (
+	"testing"
+	"time"
+
+	"github.com/busoc/timutil"
+)
+
+func TestFilename(t *testing.T) {
+	acq := time.Date(2019, 2, 3, 14, 37, 12, 0, time.UTC)
+
+	var p Packet
+	p.VMUHeader = VMUHeader{Channel: VIC1, Origin: 0x33, Sequence: 7}
+	p.VMUHeader.Coarse = uint32(acq.Add(30*time.Second).Sub(timutil.GPS) / time.Second)
+	p.DataHeader = DataHeader{Property: 0x20, Origin: 0x33, Counter: 10, Type: PNG}
+	p.DataHeader.AcqTime = acq.Sub(timutil.GPS)
+	copy(p.DataHeader.UPI[:], "TEST")
+
+	// the names given before naming templates, delta included
+	want := "0033_TEST_1_000010_20190203_143712_%!d(float64=0000000.5).dat"
+	if got := p.Filename(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	n, err := ParseNaming("{upi}/{acq:2006/002}/{counter}.{type}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n.Filename(p, ""), "TEST/2019/034/10.png"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
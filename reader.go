@@ -0,0 +1,68 @@
+package vmu
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+)
+
+// HRDLReader reads HRDL frames from a stream of bare frames delimited by
+// Syncword, as written by Packet.Marshal. Each call to Read returns exactly
+// one frame; bytes found between frames are discarded.
+type HRDLReader struct {
+	inner  *bufio.Reader
+	offset int64
+}
+
+// NewHRDLReader returns a reader of the frames of r. Only the headers are
+// buffered: frames are read directly in the buffer given to Read.
+func NewHRDLReader(r io.Reader) *HRDLReader {
+	return &HRDLReader{inner: bufio.NewReader(r)}
+}
+
+func (r *HRDLReader) Read(bs []byte) (int, error) {
+	if err := r.sync(); err != nil {
+		return 0, err
+	}
+	header, err := r.inner.Peek(HRDLHeaderLen)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	size := HRDLHeaderLen + int(binary.LittleEndian.Uint32(header[4:])) + HRDLTrailerLen
+	if size > BufferSize {
+		n, _ := r.inner.Discard(4)
+		r.offset += int64(n)
+		return 0, ErrSkip
+	}
+	if size > len(bs) {
+		return 0, io.ErrShortBuffer
+	}
+	n, err := io.ReadFull(r.inner, bs[:size])
+	r.offset += int64(n)
+	return n, err
+}
+
+// Offset returns the number of bytes consumed from the underlying reader,
+// including the bytes discarded between frames.
+func (r *HRDLReader) Offset() int64 {
+	return r.offset
+}
+
+func (r *HRDLReader) sync() error {
+	for {
+		word, err := r.inner.Peek(4)
+		if err != nil {
+			return err
+		}
+		if binary.BigEndian.Uint32(word) == Syncword {
+			return nil
+		}
+		if _, err := r.inner.Discard(1); err != nil {
+			return err
+		}
+		r.offset++
+	}
+}
@@ -0,0 +1,153 @@
+package vmu
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Discard describes a range of bytes dropped by Repair.
+type Discard struct {
+	Offset int
+	Length int
+	Reason string
+}
+
+var syncBytes = []byte{0xf8, 0x2e, 0x35, 0x53}
+
+// Repair scans r byte by byte for Syncword and writes to w every frame whose
+// length and checksum are valid. When hrdp is set, the HRDP header preceding
+// each frame is kept and its size is updated to match the frame; frames
+// without room for a HRDP header are then dropped. Repair returns the number
+// of packets written and the ranges of bytes that have been dropped.
+//
+// Only a window of r large enough to hold the longest frame is kept in memory.
+func Repair(w io.Writer, r io.Reader, hrdp bool) (int, []Discard, error) {
+	const frameLen = HRDLHeaderLen + BufferSize + HRDLTrailerLen
+	var (
+		rs = bufio.NewReader(r)
+		// buf holds the bytes of r starting at offset base
+		buf  = make([]byte, 0, HRDPHeaderLen+2*frameLen)
+		base int
+		eof  bool
+
+		pos    int
+		kept   int
+		count  int
+		reason string
+		ds     []Discard
+	)
+	fail := func(why string) {
+		if reason == "" {
+			reason = why
+		}
+	}
+	discard := func(end int) {
+		if end > kept {
+			if reason == "" {
+				reason = "junk"
+			}
+			ds = append(ds, Discard{Offset: kept, Length: end - kept, Reason: reason})
+		}
+		reason = ""
+	}
+	// fill drops the bytes that can not be part of a frame anymore and reads
+	// r until buf is full.
+	fill := func() error {
+		if drop := pos - HRDPHeaderLen - base; drop > 0 {
+			n := copy(buf, buf[drop:])
+			buf, base = buf[:n], base+drop
+		}
+		n, err := io.ReadFull(rs, buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			eof, err = true, nil
+		}
+		return err
+	}
+	for {
+		if !eof && base+len(buf)-pos < frameLen {
+			if err := fill(); err != nil {
+				return count, ds, err
+			}
+		}
+		if pos >= base+len(buf) {
+			break
+		}
+		ix := bytes.Index(buf[pos-base:], syncBytes)
+		if ix < 0 {
+			if eof {
+				break
+			}
+			// the end of buf can be the start of a syncword
+			if end := base + len(buf) - len(syncBytes) + 1; end > pos {
+				pos = end
+			}
+			continue
+		}
+		ix += pos
+		if ix+HRDLHeaderLen > base+len(buf) {
+			if !eof {
+				pos = ix
+				continue
+			}
+			fail("truncated packet")
+			break
+		}
+		size := int(binary.LittleEndian.Uint32(buf[ix-base+4:]))
+		if size < VMUHeaderLen || size > BufferSize {
+			fail("bad length")
+			pos = ix + 1
+			continue
+		}
+		total := HRDLHeaderLen + size + HRDLTrailerLen
+		if ix+total > base+len(buf) {
+			if !eof {
+				pos = ix
+				continue
+			}
+			fail("truncated packet")
+			pos = ix + 1
+			continue
+		}
+		pos = ix + 1
+		frame := buf[ix-base : ix-base+total]
+		switch _, err := decodePacket(frame, true); err {
+		case nil:
+		case ErrInvalid:
+			d := Diagnose(frame)
+			fail(fmt.Sprintf("invalid checksum (%s, delta %+d)", d.Hint, d.Delta))
+			continue
+		default:
+			fail("malformed packet")
+			continue
+		}
+		start := ix
+		if hrdp {
+			if ix-HRDPHeaderLen < kept {
+				fail("missing hrdp header")
+				continue
+			}
+			start = ix - HRDPHeaderLen
+		}
+		discard(start)
+
+		if hrdp {
+			header := make([]byte, HRDPHeaderLen)
+			copy(header, buf[start-base:ix-base])
+			binary.LittleEndian.PutUint32(header, uint32(HRDPHeaderLen-4+total))
+			if _, err := w.Write(header); err != nil {
+				return count, ds, err
+			}
+		}
+		if _, err := w.Write(frame); err != nil {
+			return count, ds, err
+		}
+		count++
+		kept, pos = ix+total, ix+total
+	}
+	discard(base + len(buf))
+	return count, ds, nil
+}
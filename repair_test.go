@@ -0,0 +1,60 @@
+package vmu_test
+
+import (
+	"bytes"
+	"testing"
+	"testing/iotest"
+
+	"github.com/busoc/vmu"
+	"github.com/busoc/vmu/vmutest"
+)
+
+func TestRepairStreamsLargeArchives(t *testing.T) {
+	cfg := vmutest.Default()
+	// archives larger than the window of Repair
+	cfg.Count, cfg.Width, cfg.Height = 40, 1024, 1024
+	cfg.Channels = []uint8{vmu.VIC1, vmu.LRSD}
+
+	for _, hrdp := range []bool{true, false} {
+		cfg.HRDL = !hrdp
+		var (
+			damaged bytes.Buffer
+			want    bytes.Buffer
+			junk    []vmu.Discard
+			g       = vmutest.New(cfg)
+		)
+		for i := 0; i < cfg.Count; i++ {
+			_, buf, err := g.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want.Write(buf)
+			damaged.Write(buf)
+			if i%5 == 2 {
+				j := bytes.Repeat([]byte{0xf8, 0x2e, 0x35}, 7+i)
+				junk = append(junk, vmu.Discard{Offset: damaged.Len(), Length: len(j)})
+				damaged.Write(j)
+			}
+		}
+
+		var got bytes.Buffer
+		count, ds, err := vmu.Repair(&got, iotest.HalfReader(&damaged), hrdp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != cfg.Count {
+			t.Errorf("hrdp=%t: %d packets recovered, want %d", hrdp, count, cfg.Count)
+		}
+		if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Errorf("hrdp=%t: repaired archive differs from the original", hrdp)
+		}
+		if len(ds) != len(junk) {
+			t.Fatalf("hrdp=%t: %d ranges discarded, want %d", hrdp, len(ds), len(junk))
+		}
+		for i := range ds {
+			if ds[i].Offset != junk[i].Offset || ds[i].Length != junk[i].Length {
+				t.Errorf("hrdp=%t: discard %d: got %d+%d, want %d+%d", hrdp, i, ds[i].Offset, ds[i].Length, junk[i].Offset, junk[i].Length)
+			}
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package vmu
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+var errShort = errors.New("payload too short for record")
+
+// Schema describes the layout of the payload of a LRSD stream identified by
+// its UPI or its stream id. Binary payloads are a sequence of records made of
+// the listed fields. Text payloads are lines of values separated by Comma
+// (default ,) whose columns are named by the fields.
+//
+// Schemas are written in JSON, eg:
+//
+//	{
+//	  "upi": "PTE-TEMP",
+//	  "endian": "big",
+//	  "fields": [
+//	    {"name": "status", "type": "u8", "bits": [
+//	      {"name": "heater", "shift": 0, "width": 1},
+//	      {"name": "mode", "shift": 1, "width": 3}
+//	    ]},
+//	    {"name": "count", "type": "u16"},
+//	    {"name": "sample", "count_by": "count", "fields": [
+//	      {"name": "temperature", "type": "i16", "scale": 0.01, "offset": -40},
+//	      {"name": "raw", "type": "u8", "count": 4}
+//	    ]},
+//	    {"name": "crc", "type": "u32", "endian": "little"}
+//	  ]
+//	}
+//
+// Arrays and repeated groups are flattened into columns named name[i] and
+// name[i].field, bit fields into columns named name.bit or name[i].bit. Since
+// count_by makes the number of columns depend on the payload, the csv header
+// is the one of the first record.
+type Schema struct {
+	UPI    string        `json:"upi"`
+	Stream uint16        `json:"stream"`
+	Format string        `json:"format"`
+	Comma  string        `json:"comma"`
+	Endian string        `json:"endian"`
+	Fields []SchemaField `json:"fields"`
+}
+
+// SchemaField is a value of type u8, u16, u32, u64, i8, i16, i32, i64, f32 or
+// f64, or a group of fields when Fields is set. Count and CountBy, the name
+// of a previous integer field of the same group or of an enclosing one, repeat
+// the field. Scale and Offset calibrate the raw value. Bits split an unsigned
+// value in bit fields.
+type SchemaField struct {
+	Name    string        `json:"name"`
+	Type    string        `json:"type"`
+	Endian  string        `json:"endian"`
+	Count   int           `json:"count"`
+	CountBy string        `json:"count_by"`
+	Scale   float64       `json:"scale"`
+	Offset  float64       `json:"offset"`
+	Bits    []BitField    `json:"bits"`
+	Fields  []SchemaField `json:"fields"`
+
+	order binary.ByteOrder
+}
+
+type BitField struct {
+	Name   string  `json:"name"`
+	Shift  uint    `json:"shift"`
+	Width  uint    `json:"width"`
+	Scale  float64 `json:"scale"`
+	Offset float64 `json:"offset"`
+}
+
+// LoadSchema reads and checks the schema stored in file.
+func LoadSchema(file string) (*Schema, error) {
+	bs, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var s Schema
+	if err := json.Unmarshal(bs, &s); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	if err := s.init(); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	return &s, nil
+}
+
+// Register registers s for the UPI and/or the stream it describes.
+func (s *Schema) Register() error {
+	if s.UPI == "" && s.Stream == 0 {
+		return fmt.Errorf("schema without upi nor stream")
+	}
+	if s.UPI != "" {
+		RegisterScience(s.UPI, s)
+	}
+	if s.Stream != 0 {
+		RegisterStream(s.Stream, s)
+	}
+	return nil
+}
+
+func (s *Schema) init() error {
+	order, err := byteOrder(s.Endian, binary.BigEndian)
+	if err != nil {
+		return err
+	}
+	if len(s.Fields) == 0 {
+		return fmt.Errorf("schema without fields")
+	}
+	switch s.Format {
+	case "text":
+		return nil
+	case "", "binary":
+	default:
+		return fmt.Errorf("unknown format %s", s.Format)
+	}
+	return initFields(s.Fields, order)
+}
+
+func initFields(fs []SchemaField, order binary.ByteOrder) error {
+	for i := range fs {
+		f := &fs[i]
+		o, err := byteOrder(f.Endian, order)
+		if err != nil {
+			return fmt.Errorf("%s: %s", f.Name, err)
+		}
+		f.order = o
+		if f.Count < 0 {
+			return fmt.Errorf("%s: negative count", f.Name)
+		}
+		if len(f.Fields) > 0 {
+			if err := initFields(f.Fields, o); err != nil {
+				return fmt.Errorf("%s.%s", f.Name, err)
+			}
+			continue
+		}
+		n := typeSize(f.Type)
+		if n == 0 {
+			return fmt.Errorf("%s: unknown type %s", f.Name, f.Type)
+		}
+		if len(f.Bits) > 0 && f.Type[0] != 'u' {
+			return fmt.Errorf("%s: bit fields of %s", f.Name, f.Type)
+		}
+		for _, b := range f.Bits {
+			if b.Width == 0 || b.Width > 64 || b.Shift+b.Width > uint(n*8) {
+				return fmt.Errorf("%s.%s: bits out of range", f.Name, b.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func byteOrder(endian string, def binary.ByteOrder) (binary.ByteOrder, error) {
+	switch endian {
+	case "":
+		return def, nil
+	case "big":
+		return binary.BigEndian, nil
+	case "little":
+		return binary.LittleEndian, nil
+	default:
+		return nil, fmt.Errorf("unknown endianness %s", endian)
+	}
+}
+
+// Decode decodes records from data until it is exhausted. Trailing bytes too
+// short to hold a record are ignored.
+func (s *Schema) Decode(data []byte) ([]Record, error) {
+	if s.Format == "text" {
+		return s.text().Decode(data)
+	}
+	var rs []Record
+	for len(data) > 0 {
+		var (
+			rec  Record
+			vars = make(map[string]uint64)
+		)
+		n, err := decodeFields(s.Fields, data, "", &rec, vars)
+		if err == errShort && len(rs) > 0 {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			break
+		}
+		rs = append(rs, rec)
+		data = data[n:]
+	}
+	return rs, nil
+}
+
+func decodeFields(fs []SchemaField, data []byte, prefix string, rec *Record, vars map[string]uint64) (int, error) {
+	var offset int
+	for _, f := range fs {
+		count, array := 1, f.Count > 0 || f.CountBy != ""
+		if f.Count > 0 {
+			count = f.Count
+		}
+		if f.CountBy != "" {
+			v, ok := lookupVar(vars, prefix, f.CountBy)
+			if !ok {
+				return offset, fmt.Errorf("%s: unknown count field %s", f.Name, f.CountBy)
+			}
+			if int64(v) < 0 {
+				return offset, fmt.Errorf("%s: negative count %d", f.Name, int64(v))
+			}
+			// every repetition takes at least one byte
+			if v > uint64(len(data)-offset) {
+				return offset, fmt.Errorf("%s: count %d larger than payload", f.Name, v)
+			}
+			count = int(v)
+		}
+		for i := 0; i < count; i++ {
+			name := prefix + f.Name
+			if array {
+				name = fmt.Sprintf("%s[%d]", name, i)
+			}
+			if len(f.Fields) > 0 {
+				n, err := decodeFields(f.Fields, data[offset:], name+".", rec, vars)
+				offset += n
+				if err != nil {
+					return offset, err
+				}
+				if n == 0 {
+					return offset, fmt.Errorf("%s: group without bytes", name)
+				}
+				continue
+			}
+			size := typeSize(f.Type)
+			if len(data)-offset < size {
+				return offset, errShort
+			}
+			raw := readValue(data[offset:], f.Type, f.order)
+			offset += size
+
+			u, isInt := toUint(raw)
+			if isInt && !array {
+				vars[name] = u
+			}
+			if len(f.Bits) == 0 {
+				*rec = append(*rec, Field{Name: name, Value: calibrate(raw, f.Scale, f.Offset)})
+				continue
+			}
+			for _, b := range f.Bits {
+				v := (u >> b.Shift) & (math.MaxUint64 >> (64 - b.Width))
+				*rec = append(*rec, Field{Name: name + "." + b.Name, Value: calibrate(v, b.Scale, b.Offset)})
+			}
+		}
+	}
+	return offset, nil
+}
+
+// lookupVar returns the value of the integer field name decoded in the group
+// of prefix or, failing that, in the closest enclosing group.
+func lookupVar(vars map[string]uint64, prefix, name string) (uint64, bool) {
+	for {
+		if v, ok := vars[prefix+name]; ok || prefix == "" {
+			return v, ok
+		}
+		prefix = prefix[:strings.LastIndex(prefix[:len(prefix)-1], ".")+1]
+	}
+}
+
+func calibrate(v interface{}, scale, offset float64) interface{} {
+	if scale == 0 && offset == 0 {
+		return v
+	}
+	if scale == 0 {
+		scale = 1
+	}
+	return toFloat(v)*scale + offset
+}
+
+func (s *Schema) text() Text {
+	t := Text{Names: make([]string, len(s.Fields))}
+	for i, f := range s.Fields {
+		t.Names[i] = f.Name
+	}
+	if rs := []rune(s.Comma); len(rs) > 0 {
+		t.Comma = rs[0]
+	}
+	return t
+}
+
+func typeSize(t string) int {
+	switch t {
+	case "u8", "i8":
+		return 1
+	case "u16", "i16":
+		return 2
+	case "u32", "i32", "f32":
+		return 4
+	case "u64", "i64", "f64":
+		return 8
+	default:
+		return 0
+	}
+}
+
+func readValue(bs []byte, t string, order binary.ByteOrder) interface{} {
+	switch t {
+	case "u8":
+		return bs[0]
+	case "i8":
+		return int8(bs[0])
+	case "u16":
+		return order.Uint16(bs)
+	case "i16":
+		return int16(order.Uint16(bs))
+	case "u32":
+		return order.Uint32(bs)
+	case "i32":
+		return int32(order.Uint32(bs))
+	case "u64":
+		return order.Uint64(bs)
+	case "i64":
+		return int64(order.Uint64(bs))
+	case "f32":
+		return math.Float32frombits(order.Uint32(bs))
+	case "f64":
+		return math.Float64frombits(order.Uint64(bs))
+	default:
+		return nil
+	}
+}
+
+func toUint(v interface{}) (uint64, bool) {
+	switch v := v.(type) {
+	case uint8:
+		return uint64(v), true
+	case uint16:
+		return uint64(v), true
+	case uint32:
+		return uint64(v), true
+	case uint64:
+		return v, true
+	case int8:
+		return uint64(v), true
+	case int16:
+		return uint64(v), true
+	case int32:
+		return uint64(v), true
+	case int64:
+		return uint64(v), true
+	default:
+		return 0, false
+	}
+}
+
+func toFloat(v interface{}) float64 {
+	switch v := v.(type) {
+	case float32:
+		return float64(v)
+	case float64:
+		return v
+	case int8:
+		return float64(v)
+	case int16:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	default:
+		u, _ := toUint(v)
+		return float64(u)
+	}
+}
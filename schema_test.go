@@ -0,0 +1,131 @@
+package vmu
+
+import (
+	"strings"
+	"testing"
+)
+
+func testSchema(t *testing.T, fs ...SchemaField) *Schema {
+	t.Helper()
+	s := Schema{UPI: "TEST", Fields: fs}
+	if err := s.init(); err != nil {
+		t.Fatal(err)
+	}
+	return &s
+}
+
+func TestSchemaBitFieldsOfArrays(t *testing.T) {
+	s := testSchema(t,
+		SchemaField{Name: "status", Type: "u8", Bits: []BitField{{Name: "on", Width: 1}}},
+		SchemaField{Name: "flags", Type: "u8", Count: 2, Bits: []BitField{
+			{Name: "on", Width: 1},
+			{Name: "mode", Shift: 1, Width: 3},
+		}},
+	)
+	rs, err := s.Decode([]byte{0x01, 0x03, 0x0e})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 1 {
+		t.Fatalf("%d records decoded, want 1", len(rs))
+	}
+	want := []Field{
+		{Name: "status.on", Value: uint64(1)},
+		{Name: "flags[0].on", Value: uint64(1)},
+		{Name: "flags[0].mode", Value: uint64(1)},
+		{Name: "flags[1].on", Value: uint64(0)},
+		{Name: "flags[1].mode", Value: uint64(7)},
+	}
+	if len(rs[0]) != len(want) {
+		t.Fatalf("%d fields decoded, want %d", len(rs[0]), len(want))
+	}
+	for i, f := range rs[0] {
+		if f != want[i] {
+			t.Errorf("field %d: got %s=%v, want %s=%v", i, f.Name, f.Value, want[i].Name, want[i].Value)
+		}
+	}
+}
+
+func TestSchemaNestedFields(t *testing.T) {
+	s := testSchema(t,
+		SchemaField{Name: "count", Type: "u8"},
+		SchemaField{Name: "head", Fields: []SchemaField{
+			{Name: "count", Type: "u8"},
+			{Name: "status", Type: "u8", Bits: []BitField{{Name: "on", Width: 1}}},
+			{Name: "value", Type: "u8", CountBy: "count"},
+		}},
+		SchemaField{Name: "status", Type: "u8", Bits: []BitField{{Name: "on", Width: 1}}},
+		SchemaField{Name: "value", Type: "u8", CountBy: "count"},
+	)
+	rs, err := s.Decode([]byte{2, 1, 1, 10, 0, 20, 21})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 1 {
+		t.Fatalf("%d records decoded, want 1", len(rs))
+	}
+	want := []Field{
+		{Name: "count", Value: uint8(2)},
+		{Name: "head.count", Value: uint8(1)},
+		{Name: "head.status.on", Value: uint64(1)},
+		{Name: "head.value[0]", Value: uint8(10)},
+		{Name: "status.on", Value: uint64(0)},
+		{Name: "value[0]", Value: uint8(20)},
+		{Name: "value[1]", Value: uint8(21)},
+	}
+	if len(rs[0]) != len(want) {
+		t.Fatalf("%d fields decoded, want %d", len(rs[0]), len(want))
+	}
+	for i, f := range rs[0] {
+		if f != want[i] {
+			t.Errorf("field %d: got %s=%v, want %s=%v", i, f.Name, f.Value, want[i].Name, want[i].Value)
+		}
+	}
+}
+
+func TestSchemaBadCounts(t *testing.T) {
+	data := []struct {
+		Name   string
+		Fields []SchemaField
+		Data   []byte
+		Err    string
+	}{
+		{
+			Name: "negative",
+			Fields: []SchemaField{
+				{Name: "count", Type: "i8"},
+				{Name: "value", Type: "u8", CountBy: "count"},
+			},
+			Data: []byte{0xff, 1, 2, 3},
+			Err:  "negative count",
+		},
+		{
+			Name: "too-large",
+			Fields: []SchemaField{
+				{Name: "count", Type: "u32"},
+				{Name: "value", Type: "u8", CountBy: "count"},
+			},
+			Data: []byte{0x80, 0, 0, 0},
+			Err:  "larger than payload",
+		},
+		{
+			Name: "empty-group",
+			Fields: []SchemaField{
+				{Name: "count", Type: "u8"},
+				{Name: "none", Type: "u8"},
+				{Name: "group", CountBy: "count", Fields: []SchemaField{
+					{Name: "value", Type: "u8", CountBy: "none"},
+				}},
+			},
+			Data: []byte{2, 0, 1, 2},
+			Err:  "group without bytes",
+		},
+	}
+	for _, d := range data {
+		s := testSchema(t, d.Fields...)
+		_, err := s.Decode(d.Data)
+		if err == nil || !strings.Contains(err.Error(), d.Err) {
+			t.Errorf("%s: got error %v, want %q", d.Name, err, d.Err)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package vmu
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// Field is a named value decoded from a science payload.
+type Field struct {
+	Name  string
+	Value interface{}
+}
+
+// Record is an ordered set of fields decoded from a science payload.
+type Record []Field
+
+func (r Record) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range r {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(f.Name)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(f.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Names returns the names of the fields of r.
+func (r Record) Names() []string {
+	vs := make([]string, len(r))
+	for i, f := range r {
+		vs[i] = f.Name
+	}
+	return vs
+}
+
+// Values returns the values of the fields of r formatted as strings.
+func (r Record) Values() []string {
+	vs := make([]string, len(r))
+	for i, f := range r {
+		vs[i] = formatValue(f.Value)
+	}
+	return vs
+}
+
+// ScienceDecoder turns the payload of a LRSD packet into records.
+type ScienceDecoder interface {
+	Decode([]byte) ([]Record, error)
+}
+
+// ScienceFunc is a function usable as a ScienceDecoder.
+type ScienceFunc func([]byte) ([]Record, error)
+
+func (f ScienceFunc) Decode(data []byte) ([]Record, error) {
+	return f(data)
+}
+
+var registry = struct {
+	sync.RWMutex
+	upis    map[string]ScienceDecoder
+	streams map[uint16]ScienceDecoder
+}{
+	upis:    make(map[string]ScienceDecoder),
+	streams: make(map[uint16]ScienceDecoder),
+}
+
+// RegisterScience makes d the decoder of the LRSD packets with the given UPI.
+func RegisterScience(upi string, d ScienceDecoder) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.upis[upi] = d
+}
+
+// RegisterStream makes d the decoder of the LRSD packets of the given stream.
+// Decoders registered by UPI take precedence.
+func RegisterStream(stream uint16, d ScienceDecoder) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.streams[stream] = d
+}
+
+// LookupScience returns the decoder registered for the UPI or the stream of p.
+func LookupScience(p Packet) (ScienceDecoder, bool) {
+	if p.VMUHeader.Channel != LRSD {
+		return nil, false
+	}
+	registry.RLock()
+	defer registry.RUnlock()
+	if d, ok := registry.upis[string(p.DataHeader.UserInfo())]; ok {
+		return d, ok
+	}
+	d, ok := registry.streams[p.DataHeader.Stream]
+	return d, ok
+}
+
+// Records decodes the payload of p with its registered decoder.
+func (p Packet) Records() ([]Record, error) {
+	d, ok := LookupScience(p)
+	if !ok {
+		return nil, fmt.Errorf("unrecognized data type")
+	}
+	return d.Decode(p.Data)
+}
+
+func (p Packet) ExportScience(w io.Writer, format string) error {
+	rs, err := p.Records()
+	if err != nil {
+		return err
+	}
+	return ExportRecords(w, rs, format)
+}
+
+// ExportRecords writes rs to w in csv or json. The header of the csv output
+// is given by the fields of the first record.
+func ExportRecords(w io.Writer, rs []Record, format string) error {
+	switch format {
+	case "", "csv":
+		ws := csv.NewWriter(w)
+		for i, r := range rs {
+			if i == 0 {
+				ws.Write(r.Names())
+			}
+			ws.Write(r.Values())
+		}
+		ws.Flush()
+		return ws.Error()
+	case "json":
+		if rs == nil {
+			rs = []Record{}
+		}
+		return json.NewEncoder(w).Encode(rs)
+	default:
+		return fmt.Errorf("unrecognized science format")
+	}
+}
+
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Text decodes ASCII payloads made of lines of separated values. Columns
+// without a name are called fieldN. Values that look like numbers are
+// decoded as float64, others are kept as strings.
+type Text struct {
+	Comma rune
+	Names []string
+}
+
+func (t Text) Decode(data []byte) ([]Record, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimRight(data, "\x00")))
+	if t.Comma != 0 {
+		r.Comma = t.Comma
+	}
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	rs := make([]Record, 0, len(rows))
+	for _, row := range rows {
+		rec := make(Record, len(row))
+		for i, v := range row {
+			rec[i].Name = fmt.Sprintf("field%d", i+1)
+			if i < len(t.Names) {
+				rec[i].Name = t.Names[i]
+			}
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				rec[i].Value = f
+			} else {
+				rec[i].Value = v
+			}
+		}
+		rs = append(rs, rec)
+	}
+	return rs, nil
+}
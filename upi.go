@@ -15,6 +15,23 @@ func UserInfo(upi [UPILen]byte) []byte {
 	return userInfo(upibuf, upi)
 }
 
+// userInfoOrKind returns the UPI or, when it is empty, the kind of data
+// (SCIENCE, IMAGE) given by the property of the data header.
+func userInfoOrKind(upi [UPILen]byte, property uint8) []byte {
+	buf := userInfo(make([]byte, UPILen), upi)
+	if len(buf) == 0 {
+		switch property >> 4 {
+		case 1:
+			buf = upiScience
+		case 2:
+			buf = upiImage
+		default:
+			buf = []byte("unknown")
+		}
+	}
+	return buf
+}
+
 func userInfo(buf []byte, upi [UPILen]byte) []byte {
 	var n int
 	for i := 0; i < UPILen; i++ {
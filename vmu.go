@@ -8,6 +8,7 @@ import (
 	"image/jpeg"
 	"image/png"
 	"io"
+	"sync"
 	"time"
 
 	"github.com/busoc/timutil"
@@ -18,6 +19,7 @@ var (
 	ErrSkip     = errors.New("skip")
 	ErrInvalid  = errors.New("invalid packet")
 	ErrSyncword = errors.New("invalid syncword")
+	ErrClosed   = errors.New("decoder closed")
 )
 
 const Syncword = 0xf82e3553
@@ -77,10 +79,7 @@ func (i ImageType) String() string {
 	}
 }
 
-const (
-	nameFormat     = "%04x_%s_%d_%06d_%s_%09d.%s"
-	nameTimeFormat = "20060102_150405"
-)
+const nameTimeFormat = "20060102_150405"
 
 const (
 	badExt = "bad"
@@ -101,30 +100,44 @@ func (p Packet) Marshal() ([]byte, error) {
 	if len(p.Data) == 0 {
 		return nil, ErrEmpty
 	}
-	var offset int
-	size := HRDLHeaderLen + VMUHeaderLen + HRDLTrailerLen + len(p.Data)
-	if p.VMUHeader.Channel == LRSD {
-		size += SCCHeaderLen
-	} else {
-		size += IMGHeaderLen
+	header := encodeData(p.DataHeader)
+	if header == nil {
+		return nil, fmt.Errorf("unrecognized data header")
 	}
+	var offset int
+	size := HRDLHeaderLen + VMUHeaderLen + len(header) + len(p.Data) + HRDLTrailerLen
 	buf := make([]byte, size)
 
-	offset += copy(buf[offset:], encodeHRDL(VMUHeaderLen+len(p.Data)))
+	offset += copy(buf[offset:], encodeHRDL(VMUHeaderLen+len(header)+len(p.Data)))
 	offset += copy(buf[offset:], encodeVMU(p.VMUHeader))
-	offset += copy(buf[offset:], encodeData(p.DataHeader))
+	offset += copy(buf[offset:], header)
 	offset += copy(buf[offset:], p.Data)
 	binary.LittleEndian.PutUint32(buf[offset:], p.Sum)
 
 	return buf, nil
 }
 
+// MarshalHRDP returns p in the layout of rt archives: its HRDP header, whose
+// size is set to the length of the record without the size itself, followed
+// by its HRDL frame.
+func (p Packet) MarshalHRDP() ([]byte, error) {
+	frame, err := p.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, HRDPHeaderLen+len(frame))
+	p.HRDPHeader.Size = uint32(len(buf) - 4)
+	copy(buf, encodeHRDP(p.HRDPHeader))
+	copy(buf[HRDPHeaderLen:], frame)
+	return buf, nil
+}
+
 func (p Packet) Export(w io.Writer, format string) error {
 	switch p.VMUHeader.Channel {
 	case VIC1, VIC2:
 		return p.ExportImage(w, format)
 	case LRSD:
-		return fmt.Errorf("unrecognized data type")
+		return p.ExportScience(w, format)
 	default:
 		return fmt.Errorf("unrecognized data type")
 	}
@@ -137,6 +150,9 @@ func (p Packet) ExportImage(w io.Writer, format string) error {
 	var i image.Image
 
 	x, y := int(p.DataHeader.PixelsX), int(p.DataHeader.PixelsY)
+	if len(p.Data) < imageSize(p.DataHeader.Type, x, y) {
+		return fmt.Errorf("image too short for %dx%d pixels", x, y)
+	}
 	switch p.DataHeader.Type {
 	default:
 		return fmt.Errorf("unsupported image type")
@@ -168,6 +184,23 @@ func (p Packet) ExportImage(w io.Writer, format string) error {
 	return err
 }
 
+// imageSize returns the number of bytes needed by an image of type t of x by
+// y pixels.
+func imageSize(t ImageType, x, y int) int {
+	switch t {
+	case Gray:
+		return x * y
+	case Gray16BE, Gray16LE, YUY2:
+		return 2 * x * y
+	case I420:
+		return x*y + 2*((x*y)/4)
+	case RGB:
+		return 3 * x * y
+	default:
+		return 0
+	}
+}
+
 func (p Packet) DataType() string {
 	if p.VMUHeader.Channel == LRSD {
 		return datExt
@@ -177,33 +210,19 @@ func (p Packet) DataType() string {
 }
 
 func (p Packet) String() string {
-	t := p.DataHeader.Acquisition().Format(nameTimeFormat)
-	delta := p.VMUHeader.Timestamp().Sub(p.DataHeader.Acquisition()).Minutes()
-	upi, ext := p.UserInfo(), datExt
-	if len(upi) == 0 {
-		if p.VMUHeader.Channel == LRSD {
-			upi = upiScience
-		} else {
-			upi = upiImage
-			ext = p.DataHeader.Type.String()
-		}
-	}
-	return fmt.Sprintf(nameFormat, p.DataHeader.Origin, upi, p.VMUHeader.Channel, p.DataHeader.Counter, t, delta, ext)
+	return p.Filename()
 }
 
+// Filename returns the name of p given by DefaultNaming.
 func (p Packet) Filename() string {
-	return p.String()
+	return defaultNaming.Format(p, nil)
 }
 
 func (p Packet) Missing(other Packet) uint32 {
 	if p.VMUHeader.Channel != other.VMUHeader.Channel {
 		return 0
 	}
-	diff := p.VMUHeader.Sequence - other.VMUHeader.Sequence
-	if diff != p.VMUHeader.Sequence {
-		diff--
-	}
-	return diff
+	return SequenceGap(p, other).Missing()
 }
 
 func (p Packet) IsRealtime() bool {
@@ -243,7 +262,29 @@ func WithOrigin(i int, valid bool) func(VMUHeader, DataHeader, error) (bool, err
 type Decoder struct {
 	filter func(VMUHeader, DataHeader, error) (bool, error)
 	inner  io.Reader
-	buffer []byte
+	buffer *[]byte
+	// length of the last packet read
+	size int
+}
+
+var buffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, BufferSize)
+		return &buf
+	},
+}
+
+// AcquireBuffer returns a buffer of BufferSize bytes from the pool shared by
+// decoders. Give it back with ReleaseBuffer once it is not used anymore.
+func AcquireBuffer() *[]byte {
+	return buffers.Get().(*[]byte)
+}
+
+// ReleaseBuffer gives buf back to the pool shared by decoders.
+func ReleaseBuffer(buf *[]byte) {
+	if buf != nil {
+		buffers.Put(buf)
+	}
 }
 
 func NewDecoder(r io.Reader, filter func(VMUHeader, DataHeader, error) (bool, error)) *Decoder {
@@ -258,32 +299,65 @@ func NewDecoder(r io.Reader, filter func(VMUHeader, DataHeader, error) (bool, er
 	return &Decoder{
 		filter: filter,
 		inner:  r,
-		buffer: make([]byte, BufferSize),
+		buffer: AcquireBuffer(),
 	}
 }
 
-func (d *Decoder) Decode(data bool) (p Packet, err error) {
-	var (
-		keep bool
-		n    int
-	)
-	n, err = d.inner.Read(d.buffer)
-	if err != nil {
-		return
+// Close gives the buffer of d back to the pool shared by decoders. Decoding
+// afterwards returns ErrClosed.
+func (d *Decoder) Close() error {
+	ReleaseBuffer(d.buffer)
+	d.buffer = nil
+	return nil
+}
+
+// Decode returns the next packet with a copy of its payload when data is set.
+func (d *Decoder) Decode(data bool) (Packet, error) {
+	p, err := d.DecodeView(data)
+	if len(p.Data) > 0 {
+		p = p.Clone()
 	}
-	p, err = decodePacket(d.buffer[:n], data)
-	if err != nil {
-		return
+	return p, err
+}
+
+// DecodeView is like Decode but the payload of the packet is a view on the
+// buffer of d. It is only valid until the next call to d: use Clone to keep it.
+func (d *Decoder) DecodeView(data bool) (p Packet, err error) {
+	if d.buffer == nil {
+		return p, ErrClosed
 	}
-	keep, err = d.filter(p.VMUHeader, p.DataHeader, err)
-	if !keep {
-		return d.Decode(data)
+	for {
+		var (
+			keep bool
+			n    int
+		)
+		n, err = d.inner.Read(*d.buffer)
+		if err != nil {
+			return
+		}
+		d.size = n
+		p, err = decodePacket((*d.buffer)[:n], data)
+		if err != nil {
+			return
+		}
+		keep, err = d.filter(p.VMUHeader, p.DataHeader, err)
+		if keep {
+			return
+		}
 	}
-	return
+}
+
+// Diagnose returns the diagnosis of the last packet read by d, to be called
+// when it was invalid.
+func (d *Decoder) Diagnose() Diagnosis {
+	if d.buffer == nil {
+		return Diagnosis{Hint: HintUnknown}
+	}
+	return Diagnose((*d.buffer)[:d.size])
 }
 
 func (d *Decoder) Marshal() ([]byte, time.Time, error) {
-	p, err := d.Decode(true)
+	p, err := d.DecodeView(true)
 	if err != nil {
 		return nil, time.Time{}, err
 	}
@@ -291,8 +365,19 @@ func (d *Decoder) Marshal() ([]byte, time.Time, error) {
 	return buf, p.Timestamp(), err
 }
 
+// DecodePacket decodes buffer. The payload is copied when data is set.
 func DecodePacket(buffer []byte, data bool) (Packet, error) {
-	return decodePacket(buffer, data)
+	p, err := decodePacket(buffer, data)
+	if len(p.Data) > 0 {
+		p = p.Clone()
+	}
+	return p, err
+}
+
+// Clone returns p with its own copy of the payload.
+func (p Packet) Clone() Packet {
+	p.Data = append([]byte(nil), p.Data...)
+	return p
 }
 
 type HRDPHeader struct {
@@ -360,18 +445,7 @@ type DataHeader struct {
 }
 
 func (d DataHeader) UserInfo() []byte {
-	upi := userInfo(make([]byte, UPILen), d.UPI)
-	if len(upi) == 0 {
-		switch d.Property >> 4 {
-		case 1:
-			upi = upiScience
-		case 2:
-			upi = upiImage
-		default:
-			upi = []byte("unknown")
-		}
-	}
-	return upi
+	return userInfoOrKind(d.UPI, d.Property)
 }
 
 func (d DataHeader) Acquisition() time.Time {
@@ -426,11 +500,11 @@ func decodePacket(buffer []byte, data bool) (p Packet, err error) {
 	}
 	n := len(buffer)
 	if data {
-		if int(length) >= n-offset {
+		if length < 0 || length > n-offset-HRDLTrailerLen {
 			err = ErrSkip
 			return
 		}
-		p.Data = append(p.Data, buffer[offset:offset+length]...)
+		p.Data = buffer[offset : offset+length : offset+length]
 	}
 
 	sum := Sum(buffer[base : n-4])
@@ -467,6 +541,21 @@ func DecodeVMU(body []byte) (VMUHeader, error) {
 	return decodeVMU(body)
 }
 
+func encodeHRDP(h HRDPHeader) []byte {
+	buf := make([]byte, HRDPHeaderLen)
+
+	binary.LittleEndian.PutUint32(buf, h.Size)
+	binary.BigEndian.PutUint16(buf[4:], h.Error)
+	buf[6] = h.Payload
+	buf[7] = h.Channel
+	binary.BigEndian.PutUint32(buf[8:], h.PacketCoarse)
+	buf[12] = h.PacketFine
+	binary.BigEndian.PutUint32(buf[13:], h.HRDPCoarse)
+	buf[17] = h.HRDPFine
+
+	return buf
+}
+
 func encodeHRDL(n int) []byte {
 	buf := make([]byte, HRDLHeaderLen)
 
@@ -530,7 +619,6 @@ func encodeData(v DataHeader) []byte {
 	case 1:
 		copy(buf[24:], v.UPI[:])
 	case 2:
-	default:
 		buf[24] = byte(v.Type)
 
 		pixels := uint32(v.PixelsX)<<16 | uint32(v.PixelsY)
@@ -545,7 +633,6 @@ func encodeData(v DataHeader) []byte {
 		buf[43] = byte(v.Ratio)
 
 		copy(buf[44:], v.UPI[:])
-		return nil
 	}
 
 	return buf
@@ -553,6 +640,9 @@ func encodeData(v DataHeader) []byte {
 
 func decodeData(body []byte) (DataHeader, error) {
 	var v DataHeader
+	if len(body) == 0 {
+		return v, ErrSkip
+	}
 
 	v.Property = body[0]
 	var expected int
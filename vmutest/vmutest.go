@@ -0,0 +1,465 @@
+// Package vmutest generates synthetic streams of VMU packets to test and
+// demonstrate the vmu package and the vmucat commands without flight data.
+package vmutest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/busoc/timutil"
+	"github.com/busoc/vmu"
+)
+
+// AllTypes lists every image type.
+var AllTypes = []vmu.ImageType{
+	vmu.Gray,
+	vmu.Gray16BE,
+	vmu.Gray16LE,
+	vmu.YUY2,
+	vmu.I420,
+	vmu.RGB,
+	vmu.JPEG,
+	vmu.PNG,
+	vmu.H264,
+}
+
+// Config describes the stream produced by a Generator. Probabilities are
+// between 0 and 1 and are drawn for each packet.
+type Config struct {
+	Start    time.Time
+	Count    int
+	Interval time.Duration
+
+	Channels []uint8
+	Types    []vmu.ImageType
+	Width    int
+	Height   int
+	UPIs     []string
+	Size     int // size of science payloads
+
+	Playback  float64 // packets lost in a gap replayed as playback packets
+	Gaps      float64 // packets followed by lost packets
+	Resets    float64 // packets resetting their counter
+	Invalid   float64 // packets with a bad checksum
+	Truncated float64 // packets missing their last bytes
+
+	// write bare HRDL frames instead of records with a HRDP header
+	HRDL bool
+	Seed int64
+}
+
+// Default returns the configuration of a clean stream of 100 packets mixing
+// every channel and every image type.
+func Default() Config {
+	return Config{
+		Start:    time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+		Count:    100,
+		Interval: 100 * time.Millisecond,
+		Channels: []uint8{vmu.VIC1, vmu.VIC2, vmu.LRSD},
+		Types:    AllTypes,
+		Width:    64,
+		Height:   48,
+		UPIs:     []string{"TEST-SCIENCE"},
+		Size:     256,
+		Seed:     1,
+	}
+}
+
+type counterKey struct {
+	Origin uint8
+	UPI    string
+}
+
+// lost is a realtime packet lost in a gap that can be replayed as a playback
+// packet once the next realtime packet of its channel has been sent.
+type lost struct {
+	Channel uint8
+	Index   int // index of the packet giving its type and UPI
+	AcqTime time.Time
+	Counter uint32
+	After   time.Time
+}
+
+// Generator produces the packets described by its Config.
+type Generator struct {
+	cfg  Config
+	rand *rand.Rand
+
+	n         int
+	sequences map[uint8]uint32
+	counters  map[counterKey]uint32
+	lost      []lost
+}
+
+func New(cfg Config) *Generator {
+	d := Default()
+	if len(cfg.Channels) == 0 {
+		cfg.Channels = d.Channels
+	}
+	if len(cfg.Types) == 0 {
+		cfg.Types = d.Types
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		cfg.Width, cfg.Height = d.Width, d.Height
+	}
+	// YUY2 and I420 need even sizes
+	cfg.Width, cfg.Height = cfg.Width&^1, cfg.Height&^1
+	if len(cfg.UPIs) == 0 {
+		cfg.UPIs = d.UPIs
+	}
+	if cfg.Size <= 0 {
+		cfg.Size = d.Size
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = d.Interval
+	}
+	if cfg.Start.IsZero() {
+		cfg.Start = d.Start
+	}
+	return &Generator{
+		cfg:       cfg,
+		rand:      rand.New(rand.NewSource(cfg.Seed)),
+		sequences: make(map[uint8]uint32),
+		counters:  make(map[counterKey]uint32),
+	}
+}
+
+// Next returns the next packet and its encoded form, which can be corrupted
+// or truncated. It returns io.EOF once Count packets have been generated.
+func (g *Generator) Next() (vmu.Packet, []byte, error) {
+	var p vmu.Packet
+	if g.cfg.Count > 0 && g.n >= g.cfg.Count {
+		return p, nil, io.EOF
+	}
+	var (
+		ch     = g.cfg.Channels[g.n%len(g.cfg.Channels)]
+		ix     = g.n
+		when   = g.cfg.Start.Add(time.Duration(g.n) * g.cfg.Interval)
+		replay *lost
+		acq    time.Time
+		every  = g.cfg.Interval * time.Duration(len(g.cfg.Channels))
+	)
+	if g.draw(g.cfg.Playback) && len(g.lost) > 0 && when.After(g.lost[0].After) {
+		r := g.lost[0]
+		g.lost, replay = g.lost[1:], &r
+		ch, ix = r.Channel, r.Index
+	}
+
+	origin := 0x30 + ch
+	p.VMUHeader = vmu.VMUHeader{
+		Channel:  ch,
+		Origin:   origin,
+		Sequence: g.sequences[ch],
+	}
+	g.sequences[ch]++
+	p.VMUHeader.Coarse, p.VMUHeader.Fine = split6(when)
+
+	if replay != nil {
+		acq = replay.AcqTime
+		p.VMUHeader.Origin = origin | 0x80
+	} else {
+		acq = when.Add(-10*time.Millisecond - time.Duration(g.rand.Intn(5000))*time.Microsecond)
+	}
+	p.DataHeader = vmu.DataHeader{
+		Origin:  origin,
+		AcqTime: acq.Sub(timutil.GPS),
+		AuxTime: acq.Sub(timutil.GPS),
+		Stream:  uint16(ch),
+	}
+	k := counterKey{Origin: origin}
+	switch ch {
+	case vmu.LRSD:
+		upi := g.cfg.UPIs[ix%len(g.cfg.UPIs)]
+		k.UPI = upi
+		p.DataHeader.Property = 0x10
+		copy(p.DataHeader.UPI[:], upi)
+	default:
+		t := g.cfg.Types[(ix/len(g.cfg.Channels))%len(g.cfg.Types)]
+		w, h := uint16(g.cfg.Width), uint16(g.cfg.Height)
+		p.DataHeader.Property = 0x20
+		p.DataHeader.Type = t
+		p.DataHeader.PixelsX, p.DataHeader.PixelsY = w, h
+		p.DataHeader.SizeX, p.DataHeader.SizeY = w, h
+		p.DataHeader.ScaleX, p.DataHeader.ScaleY = w, h
+		p.DataHeader.Ratio = 1
+		p.Data = Pattern(t, g.cfg.Width, g.cfg.Height, ix)
+	}
+	if replay != nil {
+		p.DataHeader.Counter = replay.Counter
+	} else {
+		p.DataHeader.Counter = g.counters[k]
+		g.counters[k]++
+	}
+	if ch == vmu.LRSD {
+		p.Data = Science(p.DataHeader.Counter, g.cfg.Size)
+	}
+
+	archive := when.Add(time.Second)
+	p.HRDPHeader.Channel = ch
+	p.HRDPHeader.PacketCoarse, p.HRDPHeader.PacketFine = split5(when)
+	p.HRDPHeader.HRDPCoarse, p.HRDPHeader.HRDPFine = split5(archive)
+
+	frame, err := p.Marshal()
+	if err != nil {
+		return p, nil, err
+	}
+	p.VMUHeader.Size = uint32(len(frame) - vmu.HRDLHeaderLen - vmu.HRDLTrailerLen)
+	p.Sum = vmu.Sum(frame[vmu.HRDLHeaderLen : len(frame)-vmu.HRDLTrailerLen])
+
+	var buf []byte
+	if g.cfg.HRDL {
+		buf, err = p.Marshal()
+	} else {
+		buf, err = p.MarshalHRDP()
+		p.HRDPHeader.Size = uint32(len(buf) - 4)
+	}
+	if err != nil {
+		return p, nil, err
+	}
+	if g.draw(g.cfg.Invalid) {
+		buf[len(buf)-vmu.HRDLTrailerLen-1-g.rand.Intn(len(p.Data))] ^= 0x01
+	}
+	if g.draw(g.cfg.Truncated) {
+		buf = buf[:len(buf)-1-g.rand.Intn(16)]
+		if !g.cfg.HRDL {
+			binary.LittleEndian.PutUint32(buf, uint32(len(buf)-4))
+		}
+	}
+	if replay == nil && g.draw(g.cfg.Gaps) {
+		// the lost packets are acquired between this packet and the next
+		// packet of the channel
+		n := 1 + g.rand.Intn(10)
+		for i := 0; i < n; i++ {
+			g.lost = append(g.lost, lost{
+				Channel: ch,
+				Index:   ix,
+				AcqTime: acq.Add(every * time.Duration(i+1) / time.Duration(n+1)),
+				Counter: g.counters[k] + uint32(i),
+				After:   when.Add(every),
+			})
+		}
+		g.sequences[ch] += uint32(n)
+		g.counters[k] += uint32(n)
+	}
+	if g.draw(g.cfg.Resets) {
+		delete(g.counters, k)
+	}
+	g.n++
+	return p, buf, nil
+}
+
+// WriteTo writes every packet of g to w.
+func (g *Generator) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	for {
+		_, buf, err := g.Next()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		c, err := w.Write(buf)
+		n += int64(c)
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// FileDuration is the time span of the files of rt archives.
+const FileDuration = 5 * time.Minute
+
+// Path returns the file of the rt archive in dir holding the packets archived
+// at w: dir/YYYY/DOY/HH/rt_MM_MM.dat where MM are the first and the last
+// minutes of the file.
+func Path(dir string, w time.Time) string {
+	w = w.UTC().Truncate(FileDuration)
+	var (
+		year = fmt.Sprintf("%04d", w.Year())
+		doy  = fmt.Sprintf("%03d", w.YearDay())
+		hour = fmt.Sprintf("%02d", w.Hour())
+		last = w.Add(FileDuration - time.Minute)
+	)
+	return filepath.Join(dir, year, doy, hour, fmt.Sprintf("rt_%02d_%02d.dat", w.Minute(), last.Minute()))
+}
+
+// WriteArchive writes every packet of g in the rt archive in dir, in the file
+// given by Path for its archive time. Files already in dir are truncated the
+// first time a packet is written to them.
+func (g *Generator) WriteArchive(dir string) error {
+	var (
+		file    string
+		w       *os.File
+		written = make(map[string]bool)
+	)
+	defer func() {
+		if w != nil {
+			w.Close()
+		}
+	}()
+	for {
+		p, buf, err := g.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if f := Path(dir, p.HRDPHeader.Archive()); f != file {
+			if w != nil {
+				if err := w.Close(); err != nil {
+					return err
+				}
+			}
+			if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+				return err
+			}
+			flag := os.O_CREATE | os.O_APPEND | os.O_WRONLY
+			if !written[f] {
+				flag |= os.O_TRUNC
+			}
+			if w, err = os.OpenFile(f, flag, 0644); err != nil {
+				return err
+			}
+			file, written[f] = f, true
+		}
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+	if w == nil {
+		return nil
+	}
+	err := w.Close()
+	w = nil
+	return err
+}
+
+func (g *Generator) draw(p float64) bool {
+	return p > 0 && g.rand.Float64() < p
+}
+
+// Science returns a science payload of size bytes made of records holding the
+// counter, the index of the record and a sine wave as big endian u32, u16
+// and i16.
+func Science(counter uint32, size int) []byte {
+	buf := make([]byte, size)
+	for i := 0; i+8 <= size; i += 8 {
+		ix := i / 8
+		v := int16(1000 * math.Sin(float64(counter)/10+float64(ix)/4))
+		binary.BigEndian.PutUint32(buf[i:], counter)
+		binary.BigEndian.PutUint16(buf[i+4:], uint16(ix))
+		binary.BigEndian.PutUint16(buf[i+6:], uint16(v))
+	}
+	return buf
+}
+
+// Pattern returns the pixels of a w x h test image of type t. The pattern
+// moves with frame.
+func Pattern(t vmu.ImageType, w, h, frame int) []byte {
+	var buf []byte
+	switch t {
+	case vmu.Gray:
+		buf = make([]byte, 0, w*h)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				buf = append(buf, uint8(x+y+frame))
+			}
+		}
+	case vmu.Gray16BE, vmu.Gray16LE:
+		var order binary.ByteOrder = binary.BigEndian
+		if t == vmu.Gray16LE {
+			order = binary.LittleEndian
+		}
+		buf = make([]byte, 2*w*h)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				order.PutUint16(buf[2*(y*w+x):], uint16((x+frame)*65535/w))
+			}
+		}
+	case vmu.YUY2:
+		buf = make([]byte, 0, 2*w*h)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x += 2 {
+				buf = append(buf, uint8(x+frame), uint8(y*255/h), uint8(x+1+frame), uint8(x*255/w))
+			}
+		}
+	case vmu.I420:
+		buf = make([]byte, 0, w*h*3/2)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				buf = append(buf, uint8(x+y+frame))
+			}
+		}
+		for i := 0; i < w*h/4; i++ {
+			buf = append(buf, uint8(i))
+		}
+		for i := 0; i < w*h/4; i++ {
+			buf = append(buf, uint8(255-i))
+		}
+	case vmu.RGB:
+		buf = make([]byte, 0, 3*w*h)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				c := rgbAt(x, y, w, h, frame)
+				buf = append(buf, c.R, c.G, c.B)
+			}
+		}
+	case vmu.JPEG, vmu.PNG:
+		i := image.NewRGBA(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				i.Set(x, y, rgbAt(x, y, w, h, frame))
+			}
+		}
+		var b bytes.Buffer
+		if t == vmu.JPEG {
+			jpeg.Encode(&b, i, nil)
+		} else {
+			png.Encode(&b, i)
+		}
+		buf = b.Bytes()
+	default:
+		// an access unit delimiter followed by filler data: enough to look
+		// like an h264 stream without an encoder.
+		buf = []byte{0, 0, 0, 1, 0x09, 0xf0, 0, 0, 0, 1, 0x0c}
+		for i := 0; i < w; i++ {
+			buf = append(buf, 0xff)
+		}
+		buf = append(buf, 0x80)
+	}
+	return buf
+}
+
+func rgbAt(x, y, w, h, frame int) color.RGBA {
+	return color.RGBA{
+		R: uint8(x * 255 / w),
+		G: uint8(y * 255 / h),
+		B: uint8(frame),
+		A: 255,
+	}
+}
+
+// split6 returns the coarse and fine parts (1/65536s) of t since GPS epoch.
+func split6(t time.Time) (uint32, uint16) {
+	d := t.Sub(timutil.GPS)
+	return uint32(d / time.Second), uint16((d % time.Second) * (1 << 16) / time.Second)
+}
+
+// split5 returns the coarse and fine parts (1/256s) of t since GPS epoch.
+func split5(t time.Time) (uint32, uint8) {
+	d := t.Sub(timutil.GPS)
+	return uint32(d / time.Second), uint8((d % time.Second) * (1 << 8) / time.Second)
+}
@@ -0,0 +1,115 @@
+package vmutest
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPath(t *testing.T) {
+	w := time.Date(2019, 2, 3, 14, 37, 12, 0, time.UTC)
+	want := filepath.Join("data", "2019", "034", "14", "rt_35_39.dat")
+	if got := Path("data", w); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestWriteArchive(t *testing.T) {
+	cfg := Default()
+	cfg.Count, cfg.Interval = 200, 5*time.Second
+
+	dir := t.TempDir()
+	if err := New(cfg).WriteArchive(dir); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		files []string
+		g     = New(cfg)
+	)
+	for {
+		p, _, err := g.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := Path(dir, p.HRDPHeader.Archive())
+		if len(files) == 0 || files[len(files)-1] != f {
+			files = append(files, f)
+		}
+	}
+	// 200 packets every 5s span 17 minutes
+	if len(files) < 4 {
+		t.Fatalf("%d files expected, want at least 4", len(files))
+	}
+	sizes := make(map[string]int64)
+	for _, f := range files {
+		i, err := os.Stat(f)
+		if err != nil || i.Size() == 0 {
+			t.Errorf("%s: missing or empty file", f)
+			continue
+		}
+		sizes[f] = i.Size()
+	}
+
+	// writing the archive again replaces its files
+	if err := New(cfg).WriteArchive(dir); err != nil {
+		t.Fatal(err)
+	}
+	for f, size := range sizes {
+		if i, err := os.Stat(f); err != nil || i.Size() != size {
+			t.Errorf("%s: file not replaced", f)
+		}
+	}
+}
+
+func TestPlaybackReplaysLostCounters(t *testing.T) {
+	cfg := Default()
+	cfg.Count, cfg.Gaps, cfg.Playback = 2000, 0.05, 0.2
+
+	type key struct {
+		Origin uint8
+		UPI    string
+	}
+	var (
+		g        = New(cfg)
+		realtime = make(map[key]map[uint32]bool)
+		last     = make(map[key]uint32)
+		replayed = make(map[key][]uint32)
+	)
+	for {
+		p, _, err := g.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		k := key{Origin: p.DataHeader.Origin, UPI: string(p.DataHeader.UserInfo())}
+		c := p.DataHeader.Counter
+		if p.IsRealtime() {
+			if realtime[k] == nil {
+				realtime[k] = make(map[uint32]bool)
+			}
+			realtime[k][c], last[k] = true, c
+			continue
+		}
+		if c >= last[k] {
+			t.Errorf("%02x: playback of counter %d before it is lost (last realtime %d)", k.Origin, c, last[k])
+		}
+		replayed[k] = append(replayed[k], c)
+	}
+	if len(replayed) == 0 {
+		t.Fatalf("no playback packets generated")
+	}
+	for k, cs := range replayed {
+		for _, c := range cs {
+			if realtime[k][c] {
+				t.Errorf("%02x: counter %d replayed and received in realtime", k.Origin, c)
+			}
+		}
+	}
+}